@@ -1,26 +1,89 @@
 package main
 
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Version 程序版本号，可以在编译时通过 -ldflags "-X main.Version=x.y.z" 指定
+var Version = "1.0.0"
+
 // App 应用入口
 type App struct {
-	ui  *UIMainWindow `label:"应用界面"`
-	opt *Options      `label:"配置选项"`
-	exe *Execute      `label:"指令执行"`
+	opt *Options     `label:"配置选项"`
+	exe *Execute     `label:"指令执行"`
+	log *LogNotifier `label:"日志文件通知"`
 }
 
-// init 初始化应用程序
-func (app *App) init() {
+// init 初始化配置选项，configFile 为空时使用程序目录下的 config.json
+func (app *App) init(configFile string) {
 	app.opt = new(Options)
+	app.opt.Init(configFile)
+}
+
+// initExecute 初始化指令执行器，除传入的通知外按配置附加日志文件与 Webhook 通知
+func (app *App) initExecute(notifier ...Notifier) {
+	var ns = Notifiers(notifier)
+
+	if app.opt.Debug > 0 {
+		if l, err := OpenLogNotifier(app.opt, LogFile()); nil == err {
+			app.log = l
+			ns = append(ns, l)
+		}
+	}
+	if "" != app.opt.Webhook {
+		ns = append(ns, NewWebhookNotifier(app.opt, app.opt.Webhook))
+	}
+
 	app.exe = new(Execute)
-	app.ui = new(UIMainWindow)
+	app.exe.Init(app.opt, ns)
+
+	if err := app.opt.CredentialError(); nil != err {
+		app.exe.notify(EventMessage, LevelError, "凭据文件无法解密，已备份为 credential.dat.bak，需要重新输入密码与 Token", nil)
+	}
+}
 
-	app.opt.Init()
-	app.exe.Init(app.opt, app.ui.Tip, app.ui.SetCounter)
-	app.ui.Init(app.opt, app.exe)
+// clean 清理资源
+func (app *App) clean() {
+	if nil != app.exe {
+		app.exe.Close()
+	}
+	if nil != app.log {
+		app.log.Close()
+	}
 }
 
-// Run 运行应用程序
-func (app *App) Run() {
-	app.init()
+// Daemon 以无界面模式运行应用程序，收到退出信号后停止业务指令循环
+// configFile 配置文件路径，为空时使用程序目录下的 config.json
+// debug 日志级别，小于 0 时使用配置文件中的值
+func (app *App) Daemon(configFile string, debug int) int {
+	app.init(configFile)
+	if debug >= 0 {
+		app.opt.Debug = debug
+	}
+
+	var con = NewLogNotifier(app.opt, os.Stdout)
+
+	app.initExecute(con)
+	defer app.clean()
+
+	if "" == app.opt.ECid || "" == app.opt.UID {
+		os.Stdout.Write(FormatEvent(NewEvent(EventMessage, LevelFatal, "读取配置数据出错，请检查配置文件 "+app.opt.configFile+" 后重试。", nil)))
+
+		return 1
+	}
+
+	var sig = make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	app.exe.Start()
+	con.Notify(NewEvent(EventMessage, LevelInfo, "开始监听单一窗口数据目录："+app.opt.DataPath, nil))
+
+	<-sig
+
+	app.exe.Stop()
+	con.Notify(NewEvent(EventMessage, LevelInfo, "已停止运行", nil))
 
-	app.ui.Run()
+	return 0
 }
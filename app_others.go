@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Run 非 Windows 平台没有图形界面，以无界面模式运行应用程序
+func (app *App) Run() int {
+	return app.Daemon("", -1)
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package main
+
+// Run 以图形界面模式运行应用程序
+func (app *App) Run() int {
+	var ui = new(UIMainWindow)
+
+	app.init("")
+	app.initExecute(ui)
+	defer app.clean()
+
+	ui.Init(app.opt, app.exe)
+
+	return ui.Run()
+}
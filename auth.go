@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// 账号授权方式
+const (
+	AuthModeForm  = "form"  // 模拟浏览器提交后台登录表单，兼容旧版服务器
+	AuthModeToken = "token" // 通过 API 接口换取访问令牌
+)
+
+// Auth 账号授权检查，按配置的授权方式使用输入的账号登录，在业务循环之外调用，不随业务循环停止而取消
+func (exe *Execute) Auth() error {
+	var ctx = context.Background()
+	if AuthModeToken == exe.options.AuthMode {
+		return exe.authToken(ctx, false)
+	}
+
+	return exe.authForm(ctx)
+}
+
+// authForm 读取后台登录表单的 __token__ 后提交账号密码登录，只为兼容旧版服务器保留
+func (exe *Execute) authForm(ctx context.Context) error {
+	var token string
+	var url = exe.options.URL + "admin/index/login"
+	var doc, err = exe.client.GetDoc(ctx, url, nil)
+	if nil == err {
+		doc.Find("form#login-form input").Each(func(i int, s *goquery.Selection) {
+			if n, ok := s.Attr("name"); ok && "__token__" == n {
+				token, _ = s.Attr("value")
+			}
+		})
+	}
+
+	if "" == token {
+		err = errors.New("读取远程登录表单 token 失败")
+	} else {
+		var flag bool
+		var msg = &Message{}
+		var param = map[string]string{"__token__": token, "username": exe.options.UName, "password": exe.options.Password()}
+		var payload = &ClientPayload{KeepAlive: true, Method: "POST", Data: exe.mapToQS(param)}
+		var header = make(http.Header)
+
+		header.Set("X-Requested-With", "XMLHttpRequest")
+		payload.Header = &header
+		err = exe.client.GetCodec(ctx, url, payload, "json", msg)
+		if nil == err && 1 == msg.Code && nil != msg.Data {
+			if v, ok := msg.Data.(map[string]interface{}); ok && nil != v {
+				flag = exe.setAccount(v)
+			}
+		}
+
+		if nil == err && !flag {
+			err = errors.New("登录失败，请检查用户名与密码后继续")
+		}
+	}
+
+	return err
+}
+
+// authToken 通过 API 接口换取访问令牌，refresh 为 true 且保存有刷新令牌时先尝试刷新，刷新失败再用设备密钥或账号密码登录
+func (exe *Execute) authToken(ctx context.Context, refresh bool) error {
+	var err = errors.New("没有保存刷新令牌")
+	if token := exe.options.GetCredential("refresh_token"); refresh && "" != token {
+		err = exe.requestToken(ctx, map[string]string{"grant_type": "refresh_token", "refresh_token": token})
+	}
+
+	if nil != err {
+		var param = map[string]string{"username": exe.options.UName}
+		if "" != exe.options.DeviceKey {
+			param["grant_type"] = "device"
+			param["device_key"] = exe.options.DeviceKey
+		} else if pwd := exe.options.Password(); "" != pwd {
+			param["grant_type"] = "password"
+			param["password"] = pwd
+		} else {
+			return errors.New("没有保存登录密码或设备密钥，请在设置中重新登录")
+		}
+
+		err = exe.requestToken(ctx, param)
+	}
+
+	return err
+}
+
+// requestToken 调用令牌接口，成功后保存访问令牌与刷新令牌
+func (exe *Execute) requestToken(ctx context.Context, param map[string]string) error {
+	exe.client.SetBearer("")
+
+	var msg, err = exe.request(ctx, "Token", param)
+	if nil == err && 1 != msg.Code {
+		if "" != msg.Msg {
+			err = errors.New(msg.Msg)
+		} else {
+			err = errors.New("获取访问令牌失败")
+		}
+	}
+
+	if nil == err {
+		var v, _ = msg.Data.(map[string]interface{})
+		var access = exe.numToStr(v["access_token"])
+		if "" == access || !exe.setAccount(v) {
+			return errors.New("获取访问令牌失败，服务器返回的数据不完整")
+		}
+
+		var expiry time.Time
+		if n, e := strconv.ParseInt(exe.numToStr(v["expires_in"]), 10, 64); nil == e && n > 0 {
+			// 提前一分钟刷新，避免请求途中令牌过期
+			expiry = time.Now().Add(time.Duration(n)*time.Second - time.Minute)
+		}
+
+		exe.tokenExpiry = expiry
+		exe.client.SetBearer(access)
+		if refresh := exe.numToStr(v["refresh_token"]); "" != refresh {
+			exe.options.SetCredential("refresh_token", refresh)
+		}
+	}
+
+	return err
+}
+
+// tokenExpired 检查令牌授权方式下访问令牌是否已到期
+func (exe *Execute) tokenExpired() bool {
+	if AuthModeToken != exe.options.AuthMode {
+		return false
+	}
+
+	exe.authMux.Lock()
+	defer exe.authMux.Unlock()
+
+	return "" == exe.client.Bearer() || (!exe.tokenExpiry.IsZero() && time.Now().After(exe.tokenExpiry))
+}
+
+// reauth 使用保存的凭据重新登录，多个请求同时过期时只登录一次，since 之后已重新登录过的直接返回
+func (exe *Execute) reauth(ctx context.Context, since time.Time) error {
+	exe.authMux.Lock()
+	defer exe.authMux.Unlock()
+
+	if exe.authAt.After(since) {
+		return nil
+	}
+
+	var err error
+	exe.notify(EventAuthExpired, LevelDebug, "登录会话已过期，正在重新登录", nil)
+	if AuthModeToken == exe.options.AuthMode {
+		err = exe.authToken(ctx, true)
+	} else {
+		err = exe.authForm(ctx)
+	}
+
+	if nil != err {
+		exe.notify(EventAuthExpired, LevelError, "登录会话已过期，自动重新登录失败："+err.Error(), nil)
+
+		return err
+	}
+
+	exe.authAt = time.Now()
+
+	return nil
+}
+
+// setAccount 从登录返回的数据中读取用户 ID 与企业身份 ID，缺少任意一项时返回 false
+func (exe *Execute) setAccount(v map[string]interface{}) bool {
+	var id = exe.numToStr(v["id"])
+	var ecid = exe.numToStr(v["ecid"])
+	if "" == id || "0" == id || "" == ecid || "0" == ecid {
+		return false
+	}
+
+	exe.options.UID = id
+	exe.options.ECid = ecid
+
+	return true
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// 报文字符编码
+const (
+	CharsetAuto    = "auto"
+	CharsetUTF8    = "UTF-8"
+	CharsetGBK     = "GBK"
+	CharsetGB18030 = "GB18030"
+)
+
+// 字节顺序标记
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomGB18030 = []byte{0x84, 0x31, 0x95, 0x33}
+)
+
+// xmlEncodingRe 匹配 XML 声明中的 encoding 属性
+var xmlEncodingRe = regexp.MustCompile(`^(\s*<\?xml\b[^>]*?\bencoding\s*=\s*["'])([^"']*)(["'])`)
+
+// xmlDeclRe 匹配 XML 声明
+var xmlDeclRe = regexp.MustCompile(`^\s*<\?xml\b[^>]*?\?>`)
+
+// NormalizeCharset 返回字符编码的规范名称，GB2312 与 CP936 按 GBK 处理，无法识别时返回空字符串
+func NormalizeCharset(name string) string {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "utf-8", "utf8":
+		return CharsetUTF8
+	case "gbk", "gb2312", "cp936", "x-gbk", "windows-936":
+		return CharsetGBK
+	case "gb18030":
+		return CharsetGB18030
+	}
+
+	return ""
+}
+
+// DetectCharset 根据字节顺序标记或 XML 声明识别报文的字符编码，都没有时按 UTF-8 处理
+func DetectCharset(raw []byte) string {
+	if bytes.HasPrefix(raw, bomUTF8) {
+		return CharsetUTF8
+	} else if bytes.HasPrefix(raw, bomGB18030) {
+		return CharsetGB18030
+	}
+
+	if m := xmlEncodingRe.FindSubmatch(raw); nil != m {
+		if charset := NormalizeCharset(string(m[2])); "" != charset {
+			return charset
+		}
+	}
+
+	return CharsetUTF8
+}
+
+// charsetEncoding 返回字符编码对应的编解码器，UTF-8 返回 nil
+func charsetEncoding(charset string) encoding.Encoding {
+	switch charset {
+	case CharsetGBK:
+		return simplifiedchinese.GBK
+	case CharsetGB18030:
+		return simplifiedchinese.GB18030
+	}
+
+	return nil
+}
+
+// ToUTF8 把报文转换为 UTF-8 编码，去掉字节顺序标记并把 XML 声明中的编码改为 UTF-8，返回原编码
+func ToUTF8(raw []byte) ([]byte, string, error) {
+	var charset = DetectCharset(raw)
+	if bytes.HasPrefix(raw, bomUTF8) {
+		raw = raw[len(bomUTF8):]
+	} else if bytes.HasPrefix(raw, bomGB18030) {
+		raw = raw[len(bomGB18030):]
+	}
+
+	if enc := charsetEncoding(charset); nil != enc {
+		var content, err = enc.NewDecoder().Bytes(raw)
+		if nil != err {
+			return nil, charset, err
+		}
+
+		raw = setXMLEncoding(content, CharsetUTF8)
+	}
+
+	return raw, charset, nil
+}
+
+// FromUTF8 把 UTF-8 编码的报文转换为指定编码，并设置 XML 声明中的编码，charset 为 auto 时使用 XML 声明中的编码
+func FromUTF8(content []byte, charset string) ([]byte, error) {
+	if CharsetAuto == charset || "" == charset {
+		charset = DetectCharset(content)
+	} else {
+		charset = NormalizeCharset(charset)
+	}
+
+	content = bytes.TrimPrefix(content, bomUTF8)
+	if enc := charsetEncoding(charset); nil != enc {
+		var raw, err = enc.NewEncoder().Bytes(setXMLEncoding(content, charset))
+		if nil != err {
+			return nil, err
+		}
+
+		return raw, nil
+	}
+
+	// 按 UTF-8 写入时 XML 声明中的其它编码也要改为 UTF-8
+	if xmlEncodingRe.Match(content) {
+		content = setXMLEncoding(content, CharsetUTF8)
+	}
+
+	return content, nil
+}
+
+// setXMLEncoding 设置 XML 声明中的编码，没有 encoding 属性时补上，不是 UTF-8 且没有声明时添加声明
+func setXMLEncoding(content []byte, charset string) []byte {
+	if xmlEncodingRe.Match(content) {
+		return xmlEncodingRe.ReplaceAll(content, []byte("${1}"+charset+"${3}"))
+	}
+
+	if loc := xmlDeclRe.FindIndex(content); nil != loc {
+		var decl = bytes.TrimSuffix(content[loc[0]:loc[1]], []byte("?>"))
+		var buf = make([]byte, 0, len(content)+len(charset)+12)
+		buf = append(buf, bytes.TrimRight(decl, " ")...)
+		buf = append(buf, ` encoding="`+charset+`"?>`...)
+
+		return append(buf, content[loc[1]:]...)
+	}
+
+	if CharsetUTF8 == charset {
+		return content
+	}
+
+	return append([]byte(`<?xml version="1.0" encoding="`+charset+`"?>`+"\n"), content...)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// TestCharsetRoundTrip 下载报文按声明或指定的编码写入，回执读取时转换回 UTF-8，XML 声明中的编码随之改写
+func TestCharsetRoundTrip(t *testing.T) {
+	var cases = []struct {
+		content string
+		charset string
+		want    string
+		decl    string
+	}{
+		{`<?xml version="1.0" encoding="GBK"?><a>中文</a>`, CharsetAuto, CharsetGBK, `<?xml version="1.0" encoding="GBK"?>`},
+		{`<?xml version="1.0" encoding="gb2312"?><a>中文</a>`, CharsetAuto, CharsetGBK, `<?xml version="1.0" encoding="GBK"?>`},
+		{`<?xml version="1.0" encoding="UTF-8"?><a>中文</a>`, "gb18030", CharsetGB18030, `<?xml version="1.0" encoding="GB18030"?>`},
+		{`<?xml version="1.0"?><a>中文</a>`, "GBK", CharsetGBK, `<?xml version="1.0" encoding="GBK"?>`},
+		{`<a>中文</a>`, "GBK", CharsetGBK, `<?xml version="1.0" encoding="GBK"?>`},
+		{`<?xml version='1.0' encoding='GB18030'?><a>中文</a>`, CharsetAuto, CharsetGB18030, `<?xml version='1.0' encoding='GB18030'?>`},
+	}
+
+	for _, c := range cases {
+		var raw, err = FromUTF8([]byte(c.content), c.charset)
+		if nil != err {
+			t.Errorf("%s：转换为 %s 出错 %v", c.content, c.charset, err)
+			continue
+		}
+		if !bytes.HasPrefix(raw, []byte(c.decl)) {
+			t.Errorf("%s：XML 声明为 %q，应为 %q", c.content, raw, c.decl)
+		}
+		if bytes.Contains(raw, []byte("中文")) {
+			t.Errorf("%s：写入的内容仍是 UTF-8 编码", c.content)
+		}
+		if charset := DetectCharset(raw); c.want != charset {
+			t.Errorf("%s：识别的编码为 %s，应为 %s", c.content, charset, c.want)
+		}
+
+		var utf8, decl []byte
+		var name string
+		if utf8, name, err = ToUTF8(raw); nil != err || c.want != name {
+			t.Errorf("%s：转换回 UTF-8 出错 %s %v", c.content, name, err)
+			continue
+		}
+		if decl = xmlEncodingRe.FindSubmatch(utf8)[2]; CharsetUTF8 != string(decl) {
+			t.Errorf("%s：转换回 UTF-8 后声明的编码为 %s", c.content, decl)
+		}
+		if !bytes.HasSuffix(utf8, []byte("<a>中文</a>")) {
+			t.Errorf("%s：转换回 UTF-8 后内容为 %s", c.content, utf8)
+		}
+	}
+}
+
+// TestCharsetUTF8 UTF-8 报文去掉字节顺序标记，其它编码的声明改为 UTF-8，没有声明时不添加
+func TestCharsetUTF8(t *testing.T) {
+	var cases = []struct {
+		content string
+		charset string
+		want    string
+	}{
+		{"\xEF\xBB\xBF<a>中文</a>", CharsetAuto, "<a>中文</a>"},
+		{`<a>中文</a>`, "utf8", `<a>中文</a>`},
+		{`<?xml version="1.0" encoding="GBK"?><a>中文</a>`, CharsetUTF8, `<?xml version="1.0" encoding="UTF-8"?><a>中文</a>`},
+		{`<?xml version="1.0" encoding="unknown"?><a>中文</a>`, CharsetAuto, `<?xml version="1.0" encoding="UTF-8"?><a>中文</a>`},
+	}
+
+	for _, c := range cases {
+		if got, err := FromUTF8([]byte(c.content), c.charset); nil != err || c.want != string(got) {
+			t.Errorf("%q：写入的内容为 %q %v，应为 %q", c.content, got, err, c.want)
+		}
+	}
+
+	if got, charset, err := ToUTF8([]byte("\xEF\xBB\xBF<a>中文</a>")); nil != err || CharsetUTF8 != charset || "<a>中文</a>" != string(got) {
+		t.Errorf("UTF-8 回执读取为 %q %s %v", got, charset, err)
+	}
+
+	var gb, _ = simplifiedchinese.GB18030.NewEncoder().Bytes([]byte("<a>中文</a>"))
+	if got, charset, err := ToUTF8(append([]byte{0x84, 0x31, 0x95, 0x33}, gb...)); nil != err || CharsetGB18030 != charset || !bytes.HasSuffix(got, []byte("<a>中文</a>")) {
+		t.Errorf("带字节顺序标记的 GB18030 回执读取为 %q %s %v", got, charset, err)
+	}
+}
+
+// TestCharsetUnsupported GBK 无法表示的字符写入时返回错误
+func TestCharsetUnsupported(t *testing.T) {
+	if _, err := FromUTF8([]byte("<a>\U0001F600</a>"), CharsetGBK); nil == err {
+		t.Error("GBK 无法表示的字符应返回错误")
+	}
+	if _, err := FromUTF8([]byte("<a>\U0001F600</a>"), CharsetGB18030); nil != err {
+		t.Errorf("GB18030 可以表示全部字符：%v", err)
+	}
+}
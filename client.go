@@ -2,15 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"net/http/httptrace"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
@@ -18,25 +23,34 @@ import (
 )
 
 // NewClient new http client
-func NewClient(debug int, tip func(category string, level int, msg ...string)) *Client {
+func NewClient(debug int, timeout time.Duration, notifier Notifier) *Client {
 	var cookiejarOptions = cookiejar.Options{
 		PublicSuffixList: publicsuffix.List,
 	}
 	var jar, _ = cookiejar.New(&cookiejarOptions)
-
-	return &Client{
-		tip:   tip,
-		debug: debug,
+	var c = &Client{
+		notifier: notifier,
+		clock:    new(Clock),
 		client: http.Client{
-			Jar:     jar,
-			Timeout: time.Second * 10,
-		},
-		transport: &http.Transport{
-			DisableKeepAlives: true,
+			Jar: jar,
 		},
 	}
+
+	c.Configure(debug, timeout)
+
+	return c
+}
+
+// Deadline 请求各阶段的超时时间，为 0 时不限制
+type Deadline struct {
+	Connect time.Duration `label:"建立连接超时时间"`
+	Header  time.Duration `label:"请求发送完成后等待响应头超时时间"`
+	Body    time.Duration `label:"发送请求体与读取响应体的超时时间，分别计算"`
 }
 
+// connectTimeoutKey 请求上下文中建立连接超时时间的键
+type connectTimeoutKey struct{}
+
 // ClientPayload 请求内容
 type ClientPayload struct {
 	KeepAlive bool
@@ -44,18 +58,86 @@ type ClientPayload struct {
 	Data      interface{}
 	Userinfo  *url.Userinfo
 	Header    *http.Header
+	Deadline  *Deadline
+	Stream    bool
+
+	// Date 读取后填写响应头 Date 中的服务器时间，没有时为零值
+	Date time.Time
 }
 
 // Client http client
 type Client struct {
 	debug     int
-	tip       func(category string, level int, msg ...string)
+	notifier  Notifier
+	clock     *Clock
+	mux       sync.Mutex
+	bearer    string
+	deadline  Deadline
 	client    http.Client
 	transport *http.Transport
 }
 
-// Read 从 URL 读取数据
-func (c *Client) Read(url string, payload *ClientPayload) (*http.Response, error) {
+// Configure 按新的调试级别与超时时间重新配置客户端，保留登录会话
+func (c *Client) Configure(debug int, timeout time.Duration) {
+	// 建立连接的超时时间由各请求的上下文指定，没有指定时使用配置的超时时间
+	var dialer = &net.Dialer{
+		KeepAlive: time.Second * 30,
+	}
+	var dial = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		var d = timeout
+		if v, ok := ctx.Value(connectTimeoutKey{}).(time.Duration); ok {
+			d = v
+		}
+		if d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if nil != c.transport {
+		c.transport.CloseIdleConnections()
+	}
+
+	c.debug = debug
+	c.deadline = Deadline{Connect: timeout, Header: timeout, Body: timeout}
+	c.transport = &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dial,
+		TLSHandshakeTimeout: timeout,
+	}
+}
+
+// SetBearer 设置请求附带的访问令牌，为空时不附带
+func (c *Client) SetBearer(token string) {
+	c.mux.Lock()
+	c.bearer = token
+	c.mux.Unlock()
+}
+
+// Bearer 返回请求附带的访问令牌
+func (c *Client) Bearer() string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.bearer
+}
+
+// Deadline 返回默认的请求各阶段超时时间
+func (c *Client) Deadline() Deadline {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.deadline
+}
+
+// Read 从 URL 读取数据，建立连接、发送请求体与等待响应头分阶段计时，超时后取消请求，读取响应体超时后响应体读取返回错误
+func (c *Client) Read(ctx context.Context, url string, payload *ClientPayload) (*http.Response, error) {
 	if nil == payload {
 		payload = &ClientPayload{
 			Method: "GET",
@@ -103,9 +185,19 @@ func (c *Client) Read(url string, payload *ClientPayload) (*http.Response, error
 		return nil, err
 	}
 
+	c.mux.Lock()
+	var debug = c.debug
+	var deadline = c.deadline
+	var transport = c.transport
+	c.mux.Unlock()
+
 	if payload.KeepAlive {
-		c.transport.DisableKeepAlives = false
 		payload.Header.Add("Connection", "keep-alive")
+	} else {
+		req.Close = true
+	}
+	if nil != payload.Deadline {
+		deadline = *payload.Deadline
 	}
 	if "POST" == payload.Method && "" == payload.Header.Get("Content-Type") {
 		payload.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -117,28 +209,126 @@ func (c *Client) Read(url string, payload *ClientPayload) (*http.Response, error
 	if nil != payload.Header {
 		req.Header = *payload.Header
 	}
+	if bearer := c.Bearer(); "" != bearer && "" == req.Header.Get("Authorization") {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
 
-	c.client.Transport = c.transport
-
-	if 4 == c.debug && nil != c.tip {
+	if LevelDebug == debug && nil != c.notifier {
 		if dump, err := httputil.DumpRequest(req, true); nil == err && nil != dump {
-			c.tip("info", 4, "", string(dump))
+			c.notifier.Notify(NewEvent(EventDebug, LevelDebug, string(dump), nil))
 		}
 	}
 
-	resp, err = c.client.Do(req)
-	if 4 == c.debug && nil != c.tip && nil != resp {
-		if dump, err := httputil.DumpResponse(resp, true); nil == err && nil != dump {
-			c.tip("info", 4, "", string(dump))
+	// 建立连接由拨号超时限制，取得连接后计算发送请求体的时间，请求发送完成后计算等待响应头的时间，
+	// 收到响应头后计算读取响应体的时间，任一阶段超时都取消请求
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithCancel(ctx)
+
+	var phase = &phaseTimer{cancel: cancel}
+	ctx = context.WithValue(ctx, connectTimeoutKey{}, deadline.Connect)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			phase.start(deadline.Body)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			phase.start(deadline.Header)
+		},
+	})
+
+	var client = http.Client{
+		Transport:     transport,
+		Jar:           c.client.Jar,
+		CheckRedirect: c.client.CheckRedirect,
+	}
+
+	resp, err = client.Do(req.WithContext(ctx))
+	phase.stop()
+	if nil != err {
+		cancel()
+
+		return nil, err
+	}
+
+	if t, e := http.ParseTime(resp.Header.Get("Date")); nil == e {
+		payload.Date = t
+	}
+
+	var timer *time.Timer
+	if deadline.Body > 0 {
+		timer = time.AfterFunc(deadline.Body, cancel)
+	}
+	resp.Body = &deadlineBody{ReadCloser: resp.Body, timer: timer, cancel: cancel}
+
+	// 流式响应体不会结束，调试时只记录响应头
+	if LevelDebug == debug && nil != c.notifier && nil != resp {
+		if dump, err := httputil.DumpResponse(resp, !payload.Stream); nil == err && nil != dump {
+			c.notifier.Notify(NewEvent(EventDebug, LevelDebug, string(dump), nil))
 		}
 	}
 
 	return resp, err
 }
 
+// phaseTimer 请求阶段计时器，开始新阶段时重新计时，停止后不再计时
+type phaseTimer struct {
+	mux     sync.Mutex
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	stopped bool
+}
+
+// start 开始新阶段的计时，d 为 0 时不限制
+func (p *phaseTimer) start(d time.Duration) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if nil != p.timer {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if !p.stopped && d > 0 {
+		p.timer = time.AfterFunc(d, p.cancel)
+	}
+}
+
+// stop 停止计时
+func (p *phaseTimer) stop() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	p.stopped = true
+	if nil != p.timer {
+		p.timer.Stop()
+	}
+}
+
+// deadlineBody 关闭响应体时停止读取计时并释放请求上下文
+type deadlineBody struct {
+	io.ReadCloser
+	timer  *time.Timer
+	cancel context.CancelFunc
+}
+
+// Close 关闭响应体
+func (b *deadlineBody) Close() error {
+	if nil != b.timer {
+		b.timer.Stop()
+	}
+
+	var err = b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
+
+// Clock 返回根据服务器响应估算的时钟
+func (c *Client) Clock() *Clock {
+	return c.clock
+}
+
 // GetByte 从 URL 读取字节内容及状态码
-func (c *Client) GetByte(url string, payload *ClientPayload) ([]byte, *http.Response, error) {
-	var resp, err = c.Read(url, payload)
+func (c *Client) GetByte(ctx context.Context, url string, payload *ClientPayload) ([]byte, *http.Response, error) {
+	var resp, err = c.Read(ctx, url, payload)
 	if nil == err {
 		var ret []byte
 		ret, err = ioutil.ReadAll(resp.Body)
@@ -154,13 +344,15 @@ func (c *Client) GetByte(url string, payload *ClientPayload) ([]byte, *http.Resp
 }
 
 // GetDoc 从 URL 创建 goquery DOM 对象
-func (c *Client) GetDoc(url string, payload *ClientPayload) (*goquery.Document, error) {
-	var resp, err = c.Read(url, payload)
+func (c *Client) GetDoc(ctx context.Context, url string, payload *ClientPayload) (*goquery.Document, error) {
+	var resp, err = c.Read(ctx, url, payload)
 	if nil != err {
 		return nil, err
 	}
 
 	if 0 == resp.ContentLength {
+		resp.Body.Close()
+
 		return nil, errors.New("remote return is empty")
 	}
 
@@ -168,11 +360,13 @@ func (c *Client) GetDoc(url string, payload *ClientPayload) (*goquery.Document,
 }
 
 // GetCodec 从 URL 创建反序列化对象
-func (c *Client) GetCodec(url string, payload *ClientPayload, codec string, out interface{}) error {
+func (c *Client) GetCodec(ctx context.Context, url string, payload *ClientPayload, codec string, out interface{}) error {
 	var err error
 	var data []byte
 	var resp *http.Response
-	if data, resp, err = c.GetByte(url, payload); nil == err && 200 == resp.StatusCode {
+	if data, resp, err = c.GetByte(ctx, url, payload); nil == err && IsAuthExpired(url, resp) {
+		err = ErrAuthExpired
+	} else if nil == err && 200 == resp.StatusCode {
 		switch codec {
 		case "json":
 			err = json.Unmarshal(data, out)
@@ -185,11 +379,11 @@ func (c *Client) GetCodec(url string, payload *ClientPayload, codec string, out
 }
 
 // Download 下载文件
-func (c *Client) Download(url string, filename string, auto bool) error {
+func (c *Client) Download(ctx context.Context, url string, filename string, auto bool) error {
 	var err error
 	var data []byte
 	var resp *http.Response
-	if data, resp, err = c.GetByte(url, nil); nil == err && 200 == resp.StatusCode {
+	if data, resp, err = c.GetByte(ctx, url, nil); nil == err && 200 == resp.StatusCode {
 		if auto && "" == c.GetURLExt(filename) {
 			filename = filename + c.GetURLExt(resp.Request.URL.String())
 		}
@@ -213,6 +407,15 @@ func (c *Client) Download(url string, filename string, auto bool) error {
 	return err
 }
 
+// IsAuthExpired 检查响应是否表示登录会话已过期：返回 401/403 状态码或被重定向到后台登录页面
+func IsAuthExpired(url string, resp *http.Response) bool {
+	if http.StatusUnauthorized == resp.StatusCode || http.StatusForbidden == resp.StatusCode {
+		return true
+	}
+
+	return nil != resp.Request && url != resp.Request.URL.String() && strings.Contains(resp.Request.URL.Path, "admin/index/login")
+}
+
 // GetURLExt 获取 URL 中文件名扩展名
 func (c *Client) GetURLExt(url string) string {
 	var ext string
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestClientDeadline 等待响应头的时间从请求发送完成后开始计算，发送请求体的时间不计入
+func TestClientDeadline(t *testing.T) {
+	var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "/slow-upload" == r.URL.Path {
+			// 延迟读取请求体，客户端发送请求体的时间变长
+			time.Sleep(300 * time.Millisecond)
+			io.Copy(ioutil.Discard, r.Body)
+		} else {
+			io.Copy(ioutil.Discard, r.Body)
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	var c = NewClient(0, 5*time.Second, nil)
+	var deadline = &Deadline{Connect: 100 * time.Millisecond, Header: 100 * time.Millisecond, Body: 5 * time.Second}
+
+	var data = bytes.Repeat([]byte("x"), 32<<20)
+	var body, _, err = c.GetByte(context.Background(), srv.URL+"/slow-upload", &ClientPayload{Method: "POST", Data: data, Deadline: deadline})
+	if nil != err || "ok" != string(body) {
+		t.Errorf("发送请求体较慢时请求失败：%v", err)
+	}
+
+	var start = time.Now()
+	if _, _, err = c.GetByte(context.Background(), srv.URL+"/slow-header", &ClientPayload{Method: "POST", Data: "a=1", Deadline: deadline}); nil == err {
+		t.Error("等待响应头超时后应返回错误")
+	} else if d := time.Since(start); d > 400*time.Millisecond {
+		t.Errorf("等待响应头 %v 后才超时，应为 100ms", d)
+	}
+}
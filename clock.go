@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// clockSamples 估算时钟偏差保留的最近样本数
+const clockSamples = 9
+
+// Clock 根据服务器时间估算的本机时钟偏差，取最近样本的中位数以过滤网络抖动
+type Clock struct {
+	mux     sync.Mutex      `label:"样本锁"`
+	samples []time.Duration `label:"最近的偏差样本"`
+	offset  time.Duration   `label:"估算的偏差，服务器时间减本机时间"`
+}
+
+// Observe 记录一次服务器时间样本，sent 与 received 为请求发出与收到响应时的本机时间，返回新的偏差估算值
+func (c *Clock) Observe(server time.Time, sent time.Time, received time.Time) time.Duration {
+	var sample = server.Sub(sent.Add(received.Sub(sent) / 2))
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.samples = append(c.samples, sample)
+	if len(c.samples) > clockSamples {
+		c.samples = c.samples[len(c.samples)-clockSamples:]
+	}
+
+	var sorted = append([]time.Duration(nil), c.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	c.offset = sorted[len(sorted)/2]
+
+	return c.offset
+}
+
+// Offset 返回估算的偏差，服务器时间减本机时间
+func (c *Clock) Offset() time.Duration {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.offset
+}
+
+// Now 返回按偏差校正后的服务器当前时间
+func (c *Clock) Now() time.Time {
+	return time.Now().Add(c.Offset())
+}
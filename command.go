@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 上报日志时默认与最多读取的日志文件末尾字节数
+const (
+	logTailSize = 64 * 1024
+	logMaxSize  = 16 * logTailSize
+)
+
+// 传输记录命令默认与最多返回的记录条数
+const (
+	ledgerDefaultLimit = 100
+	ledgerMaxLimit     = 1000
+)
+
+// Command 服务器下发的远程命令
+type Command struct {
+	ID       string            `label:"命令 ID"`
+	Category string            `label:"完整的命令类型"`
+	Name     string            `label:"命令名称，命令类型中第一个 | 之前的部分"`
+	Args     []string          `label:"命令参数，命令类型中 | 分隔的后缀"`
+	Group    string            `label:"命令分组，同一分组的命令按顺序执行"`
+	Param    map[string]string `label:"状态回传参数"`
+}
+
+// NewCommand 从服务器返回的命令数据创建远程命令
+func NewCommand(row map[string]interface{}, param map[string]string) *Command {
+	var category, _ = row["category"].(string)
+	var parts = strings.Split(category, "|")
+	var group string
+
+	switch v := row["group"].(type) {
+	case string:
+		group = v
+	case float64:
+		group = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return &Command{
+		ID:       param["id"],
+		Category: category,
+		Name:     parts[0],
+		Args:     parts[1:],
+		Group:    group,
+		Param:    param,
+	}
+}
+
+// Arg 返回指定位置的命令参数，不存在时返回空字符串
+func (cmd *Command) Arg(i int) string {
+	if i < len(cmd.Args) {
+		return cmd.Args[i]
+	}
+
+	return ""
+}
+
+// Action 返回状态回传的动作名称，报文下载命令沿用 download 以兼容服务器
+func (cmd *Command) Action() string {
+	if "xml" == cmd.Name {
+		return "download"
+	}
+
+	return cmd.Name
+}
+
+// CommandHandler 远程命令处理函数，执行成功时由处理函数自己回传状态
+type CommandHandler func(ctx context.Context, exe *Execute, cmd *Command) error
+
+// commandHandlers 已注册的远程命令处理函数
+var commandHandlers = make(map[string]CommandHandler)
+
+// RegisterCommand 注册远程命令处理函数，同名命令后注册的覆盖先注册的
+func RegisterCommand(name string, handler CommandHandler) {
+	commandHandlers[name] = handler
+}
+
+func init() {
+	RegisterCommand("xml", commandDownload)
+	RegisterCommand("resend", commandResend)
+	RegisterCommand("delete", commandDelete)
+	RegisterCommand("reupload", commandReupload)
+	RegisterCommand("status", commandStatus)
+	RegisterCommand("log", commandLog)
+	RegisterCommand("resync", commandResync)
+	RegisterCommand("ledger", commandLedger)
+}
+
+// dispatch 执行远程命令
+func (exe *Execute) dispatch(ctx context.Context, cmd *Command) error {
+	var handler, ok = commandHandlers[cmd.Name]
+	if !ok {
+		return errors.New("未知的命令：" + cmd.Category)
+	}
+
+	return handler(ctx, exe, cmd)
+}
+
+// report 回传远程命令执行成功的状态与结果内容
+func (exe *Execute) report(ctx context.Context, cmd *Command, content string) error {
+	var param = make(map[string]string, len(cmd.Param)+3)
+	for k, v := range cmd.Param {
+		param[k] = v
+	}
+
+	param["action"] = cmd.Action()
+	param["status"] = "ok"
+	if "" != content {
+		param["content"] = content
+	}
+
+	var msg, err = exe.receipt(ctx, param)
+	exe.record(&LedgerEntry{Kind: LedgerCommand, ID: cmd.ID, Category: "report"}, msg, err)
+
+	return err
+}
+
+// commandDownload 下载报文到单一窗口导入目录
+func commandDownload(ctx context.Context, exe *Execute, cmd *Command) error {
+	var err = exe.download(ctx, cmd.Param)
+	if nil == err {
+		atomic.AddUint64(&exe.options.Counter.Download, 1)
+	}
+
+	return err
+}
+
+// commandResend 重新写入已下载的报文，让单一窗口客户端再次导入，参数为报文相对路径
+func commandResend(ctx context.Context, exe *Execute, cmd *Command) error {
+	var file, err = exe.safePath(cmd.Arg(0), exe.options.AllowPaths)
+	if nil != err {
+		return err
+	}
+
+	if !IsFile(file) {
+		return &CommandError{Reason: "需要重新发送的报文不存在：" + cmd.Arg(0)}
+	}
+
+	var content []byte
+	if content, err = FileGetContents(file); nil == err {
+		if err = FileAtomicPutContents(file, content); nil == err {
+			err = exe.report(ctx, cmd, "")
+		}
+	}
+
+	return err
+}
+
+// commandDelete 删除还没有被单一窗口客户端导入的报文，参数为报文相对路径，报文不存在视为删除成功
+func commandDelete(ctx context.Context, exe *Execute, cmd *Command) error {
+	var file, err = exe.safePath(cmd.Arg(0), exe.options.AllowPaths)
+	if nil != err {
+		return err
+	}
+
+	if err = os.Remove(file); nil == err || os.IsNotExist(err) {
+		err = exe.report(ctx, cmd, "")
+	}
+
+	return err
+}
+
+// commandReupload 重新上传回执，参数为回执相对路径
+func commandReupload(ctx context.Context, exe *Execute, cmd *Command) error {
+	var file, err = exe.safePath(cmd.Arg(0), inboxPaths)
+	if nil != err {
+		return err
+	}
+
+	if !IsFile(file) {
+		return &CommandError{Reason: "需要重新上传的回执不存在：" + cmd.Arg(0)}
+	}
+
+	err = exe.deliver(ctx, file)
+	exe.uploaded(ctx, file, err)
+	if nil == err {
+		err = exe.report(ctx, cmd, "")
+	}
+
+	return err
+}
+
+// commandStatus 上报本地运行状态
+func commandStatus(ctx context.Context, exe *Execute, cmd *Command) error {
+	var data, err = json.Marshal(exe.Status())
+	if nil == err {
+		err = exe.report(ctx, cmd, string(data))
+	}
+
+	return err
+}
+
+// commandLog 上报日志文件末尾内容，参数为读取的字节数，默认 64KB，最多 1MB
+func commandLog(ctx context.Context, exe *Execute, cmd *Command) error {
+	var size int64 = logTailSize
+	if n, err := strconv.ParseInt(cmd.Arg(0), 10, 64); nil == err && n > 0 {
+		size = n
+	}
+	if size > logMaxSize {
+		size = logMaxSize
+	}
+
+	var fp, err = os.Open(LogFile())
+	if nil != err {
+		return &CommandError{Reason: "读取日志文件失败：" + err.Error()}
+	}
+	defer fp.Close()
+
+	if fi, err := fp.Stat(); nil != err {
+		return &CommandError{Reason: "读取日志文件失败：" + err.Error()}
+	} else if fi.Size() > size {
+		fp.Seek(fi.Size()-size, io.SeekStart)
+	} else {
+		size = fi.Size()
+	}
+
+	var buf = make([]byte, size)
+	var n, _ = io.ReadFull(fp, buf)
+
+	return exe.report(ctx, cmd, string(buf[:n]))
+}
+
+// ResyncResult 重新上传回执命令的执行结果
+type ResyncResult struct {
+	Matched  int      `json:"matched" label:"匹配的回执数量"`
+	Uploaded int      `json:"uploaded" label:"上传成功的数量"`
+	Failed   []string `json:"failed" label:"上传失败的回执文件名"`
+}
+
+// commandResync 扫描回执目录，把匹配的回执重新上传，参数可以是日期范围或文件名通配符，可以同时指定：
+// 日期范围格式为 2006-01-02~2006-01-31，按文件修改时间匹配，省略开始或结束日期表示不限，只有一个日期表示当天；
+// 文件名通配符如 receipt_*.xml，不区分大小写
+func commandResync(ctx context.Context, exe *Execute, cmd *Command) error {
+	var since, until time.Time
+	var pattern string
+	for _, arg := range cmd.Args {
+		if s, u, ok := parseDateRange(arg); ok {
+			since, until = s, u
+		} else if "" != arg {
+			pattern = strings.ToLower(arg)
+			if _, err := path.Match(pattern, ""); nil != err {
+				return &CommandError{Reason: "文件名通配符不合法：" + arg}
+			}
+		}
+	}
+
+	var result = &ResyncResult{Failed: []string{}}
+	for _, dir := range exe.inboxDirs() {
+		var files, err = ioutil.ReadDir(dir)
+		if nil != err {
+			continue
+		}
+
+		for _, fi := range files {
+			if nil != ctx.Err() {
+				return ctx.Err()
+			}
+
+			var name = strings.ToLower(fi.Name())
+			if fi.IsDir() || !strings.HasSuffix(name, ".xml") {
+				continue
+			} else if !since.IsZero() && fi.ModTime().Before(since) {
+				continue
+			} else if !until.IsZero() && !fi.ModTime().Before(until) {
+				continue
+			} else if ok, _ := path.Match(pattern, name); "" != pattern && !ok {
+				continue
+			}
+
+			var file = dir + "/" + fi.Name()
+			result.Matched++
+			err = exe.deliver(ctx, file)
+			exe.uploaded(ctx, file, err)
+			if nil == err {
+				result.Uploaded++
+			} else {
+				result.Failed = append(result.Failed, fi.Name())
+			}
+		}
+	}
+
+	exe.notifyCounter()
+
+	var data, err = json.Marshal(result)
+	if nil == err {
+		err = exe.report(ctx, cmd, string(data))
+	}
+
+	return err
+}
+
+// parseDateRange 解析 2006-01-02~2006-01-31 格式的日期范围，返回 [since, until) 区间
+func parseDateRange(v string) (since time.Time, until time.Time, ok bool) {
+	var parts = strings.SplitN(v, "~", 2)
+	var days [2]time.Time
+	for i, s := range parts {
+		if s = strings.TrimSpace(s); "" != s {
+			var t, err = time.ParseInLocation("2006-01-02", s, time.Local)
+			if nil != err {
+				return since, until, false
+			}
+
+			days[i] = t
+		}
+	}
+
+	if 1 == len(parts) {
+		if days[0].IsZero() {
+			return since, until, false
+		}
+
+		return days[0], days[0].AddDate(0, 0, 1), true
+	}
+
+	if !days[1].IsZero() {
+		days[1] = days[1].AddDate(0, 0, 1)
+	}
+
+	return days[0], days[1], !days[0].IsZero() || !days[1].IsZero()
+}
+
+// LedgerResult 传输记录命令的查询结果
+type LedgerResult struct {
+	Entries []*LedgerEntry `json:"entries" label:"按时间顺序排列的传输记录"`
+	Latest  *LedgerEntry   `json:"latest,omitempty" label:"指定命令 ID 时该命令的最新执行记录"`
+}
+
+// commandLedger 上报本地传输记录，参数依次为日期范围、记录类型、处理状态、条数与远程命令 ID，都可以为空，
+// 没有日期范围时查询最近一天的记录
+func commandLedger(ctx context.Context, exe *Execute, cmd *Command) error {
+	if nil == exe.ledger {
+		return &CommandError{Reason: "本地存储不可用，没有传输记录"}
+	}
+
+	var q, err = parseLedgerQuery(cmd.Args, time.Now())
+	if nil != err {
+		return &CommandError{Reason: err.Error()}
+	}
+
+	var result = &LedgerResult{Entries: exe.ledger.Query(q)}
+	if nil == result.Entries {
+		result.Entries = []*LedgerEntry{}
+	}
+	if "" != q.ID {
+		result.Latest, _ = exe.ledger.Command(q.ID)
+	}
+
+	var data []byte
+	if data, err = json.Marshal(result); nil == err {
+		err = exe.report(ctx, cmd, string(data))
+	}
+
+	return err
+}
+
+// parseLedgerQuery 把传输记录命令的参数转换为查询条件
+func parseLedgerQuery(args []string, now time.Time) (*LedgerQuery, error) {
+	var arg = func(i int) string {
+		if i < len(args) {
+			return strings.TrimSpace(args[i])
+		}
+
+		return ""
+	}
+
+	var q = &LedgerQuery{
+		Kind:   arg(1),
+		Status: arg(2),
+		ID:     arg(4),
+		Since:  now.AddDate(0, 0, -1),
+		Limit:  ledgerDefaultLimit,
+	}
+
+	if v := arg(0); "" != v {
+		var since, until, ok = parseDateRange(v)
+		if !ok {
+			return nil, errors.New("日期范围不合法：" + v)
+		}
+
+		q.Since, q.Until = since, until
+	}
+
+	switch q.Kind {
+	case "", LedgerCommand, LedgerDownload, LedgerReceipt:
+	default:
+		return nil, errors.New("记录类型不合法：" + q.Kind)
+	}
+
+	if v := arg(3); "" != v {
+		var n, err = strconv.Atoi(v)
+		if nil != err || n <= 0 {
+			return nil, errors.New("记录条数不合法：" + v)
+		}
+
+		q.Limit = n
+	}
+	if q.Limit > ledgerMaxLimit {
+		q.Limit = ledgerMaxLimit
+	}
+
+	return q, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/user"
+	"sync"
+)
+
+// ErrCredentialCorrupt 凭据文件无法解密，可能是密钥不匹配或文件已损坏
+var ErrCredentialCorrupt = errors.New("凭据文件无法解密，请检查密钥是否正确")
+
+// CredentialStore 凭据存储，保存登录密码、签名 Token 等敏感数据
+type CredentialStore interface {
+	Get(key string) (string, error)
+	Set(key string, value string) error
+	Delete(key string) error
+}
+
+// MachineSecret 返回与本机绑定的密钥材料，凭据文件复制到其它电脑或其它用户下无法解密；
+// 密钥材料都是公开信息，能读取凭据文件的人也能重新计算出密钥，只能防止凭据以明文保存
+func MachineSecret() string {
+	var secret, _ = os.Hostname()
+	if u, err := user.Current(); nil == err {
+		secret = secret + "|" + u.Uid + "|" + u.Username
+	}
+
+	return secret + "|" + GetAppPath()
+}
+
+// credentialCipher 凭据文件内容的加解密方式
+type credentialCipher interface {
+	Seal(plain []byte) ([]byte, error)
+	Open(data []byte) ([]byte, error)
+}
+
+// NewFileCredentialStore 创建加密文件凭据存储，secret 为空时优先使用系统提供的数据保护，
+// 系统不支持时使用与本机绑定的密钥
+func NewFileCredentialStore(file string, secret string) *FileCredentialStore {
+	var ciphers []credentialCipher
+	if "" != secret {
+		ciphers = append(ciphers, newAESCipher(secret))
+	} else {
+		// 旧版本使用与本机绑定的密钥加密，保留用于读取，保存时改用系统数据保护
+		ciphers = append(systemCiphers(), newAESCipher(MachineSecret()))
+	}
+
+	return &FileCredentialStore{file: file, ciphers: ciphers}
+}
+
+// FileCredentialStore 加密保存在文件中的凭据存储，按顺序尝试各种解密方式，保存时使用第一种
+type FileCredentialStore struct {
+	file    string             `label:"凭据文件路径"`
+	ciphers []credentialCipher `label:"加解密方式"`
+	err     error              `label:"凭据文件无法解密时的错误"`
+	mux     sync.Mutex         `label:"文件读写锁"`
+}
+
+// Get 读取凭据，不存在时返回空字符串
+func (s *FileCredentialStore) Get(key string) (string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var data, err = s.load()
+
+	return data[key], err
+}
+
+// Set 保存凭据
+func (s *FileCredentialStore) Set(key string, value string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var data, err = s.load()
+	if nil == err && data[key] != value {
+		data[key] = value
+		err = s.save(data)
+	}
+
+	return err
+}
+
+// Delete 删除凭据
+func (s *FileCredentialStore) Delete(key string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var data, err = s.load()
+	if _, ok := data[key]; nil == err && ok {
+		delete(data, key)
+		err = s.save(data)
+	}
+
+	return err
+}
+
+// Err 返回凭据文件无法解密的错误，没有出错时返回 nil
+func (s *FileCredentialStore) Err() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	return s.err
+}
+
+// load 读取并解密凭据文件，文件不存在时返回空数据；无法解密时把文件改名为 .bak 备份后按空数据处理，
+// 以免密钥变化后再也无法保存凭据
+func (s *FileCredentialStore) load() (map[string]string, error) {
+	var data = make(map[string]string)
+	if !IsFile(s.file) {
+		return data, nil
+	}
+
+	var c, err = FileGetContents(s.file)
+	if nil != err || 0 == len(c) {
+		return data, err
+	}
+
+	for _, v := range s.ciphers {
+		var plain []byte
+		if plain, err = v.Open(c); nil == err {
+			if err = json.Unmarshal(plain, &data); nil == err {
+				return data, nil
+			}
+		}
+	}
+
+	s.err = ErrCredentialCorrupt
+	if err = os.Rename(s.file, s.file+".bak"); nil != err {
+		return data, err
+	}
+
+	return make(map[string]string), nil
+}
+
+// save 加密并保存凭据文件
+func (s *FileCredentialStore) save(data map[string]string) error {
+	var plain, err = json.Marshal(data)
+	if nil != err {
+		return err
+	}
+
+	var c []byte
+	if c, err = s.ciphers[0].Seal(plain); nil != err {
+		return err
+	}
+
+	return FileAtomicPutContents(s.file, c)
+}
+
+// newAESCipher 创建使用 AES-256-GCM 加密的加解密方式，密钥由 secret 计算
+func newAESCipher(secret string) *aesCipher {
+	var key = sha256.Sum256([]byte("swa credential|" + secret))
+
+	return &aesCipher{key: key[:]}
+}
+
+// aesCipher AES-256-GCM 加解密，密文前面附加随机数
+type aesCipher struct {
+	key []byte `label:"加密密钥"`
+}
+
+// Seal 加密
+func (a *aesCipher) Seal(plain []byte) ([]byte, error) {
+	var gcm, err = a.gcm()
+	if nil != err {
+		return nil, err
+	}
+
+	var nonce = make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); nil != err {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+// Open 解密
+func (a *aesCipher) Open(data []byte) ([]byte, error) {
+	var gcm, err = a.gcm()
+	if nil != err {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrCredentialCorrupt
+	}
+
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// gcm 创建加解密器
+func (a *aesCipher) gcm() (cipher.AEAD, error) {
+	var block, err = aes.NewCipher(a.key)
+	if nil != err {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
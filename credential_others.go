@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// systemCiphers 系统提供的凭据保护方式，非 Windows 系统没有可用的方式
+func systemCiphers() []credentialCipher {
+	return nil
+}
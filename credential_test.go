@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFileCredentialStoreCorrupt 凭据文件无法解密时备份原文件并按空凭据继续保存
+func TestFileCredentialStoreCorrupt(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "swa")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var file = filepath.Join(dir, "credential.dat")
+	var a = NewFileCredentialStore(file, "a")
+	if err = a.Set("password", "123"); nil != err {
+		t.Fatal(err)
+	}
+	if v, _ := a.Get("password"); "123" != v {
+		t.Fatalf("读取的凭据为 %q，应为 123", v)
+	}
+
+	var b = NewFileCredentialStore(file, "b")
+	if v, err := b.Get("password"); nil != err || "" != v {
+		t.Errorf("无法解密时应返回空凭据：%q %v", v, err)
+	}
+	if ErrCredentialCorrupt != b.Err() {
+		t.Errorf("无法解密时应记录错误")
+	}
+	if !IsFile(file + ".bak") {
+		t.Errorf("无法解密的凭据文件没有备份")
+	}
+
+	if err = b.Set("password", "456"); nil != err {
+		t.Fatalf("无法解密后不能保存凭据：%v", err)
+	}
+	if v, _ := NewFileCredentialStore(file, "b").Get("password"); "456" != v {
+		t.Errorf("重新保存的凭据为 %q，应为 456", v)
+	}
+	if err = b.Delete("password"); nil != err {
+		t.Errorf("无法解密后不能删除凭据：%v", err)
+	}
+}
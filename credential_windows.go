@@ -0,0 +1,97 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// cryptProtectUIForbidden 不允许 DPAPI 弹出界面
+const cryptProtectUIForbidden = 0x1
+
+// DPAPI 函数
+var (
+	modCrypt32             = syscall.NewLazyDLL("crypt32.dll")
+	modKernel32            = syscall.NewLazyDLL("kernel32.dll")
+	procCryptProtectData   = modCrypt32.NewProc("CryptProtectData")
+	procCryptUnprotectData = modCrypt32.NewProc("CryptUnprotectData")
+	procLocalFree          = modKernel32.NewProc("LocalFree")
+)
+
+// dpapiEntropy 附加的熵，其它程序用同一用户身份也不能直接解密
+var dpapiEntropy = []byte("swa credential")
+
+// systemCiphers 系统提供的凭据保护方式，Windows 使用与当前用户绑定的 DPAPI
+func systemCiphers() []credentialCipher {
+	return []credentialCipher{new(dpapiCipher)}
+}
+
+// dataBlob DPAPI 的 DATA_BLOB 结构
+type dataBlob struct {
+	cbData uint32
+	pbData *byte
+}
+
+// newDataBlob 创建指向 d 的 DATA_BLOB
+func newDataBlob(d []byte) *dataBlob {
+	if 0 == len(d) {
+		return new(dataBlob)
+	}
+
+	return &dataBlob{cbData: uint32(len(d)), pbData: &d[0]}
+}
+
+// bytes 复制 DATA_BLOB 中的数据并释放系统分配的内存
+func (b *dataBlob) bytes() []byte {
+	var d = make([]byte, b.cbData)
+	if b.cbData > 0 {
+		copy(d, (*[1 << 30]byte)(unsafe.Pointer(b.pbData))[:b.cbData:b.cbData])
+	}
+
+	procLocalFree.Call(uintptr(unsafe.Pointer(b.pbData)))
+
+	return d
+}
+
+// dpapiCipher 使用 Windows DPAPI 加解密，只有加密时的 Windows 用户才能解密
+type dpapiCipher struct{}
+
+// Seal 加密
+func (c *dpapiCipher) Seal(plain []byte) ([]byte, error) {
+	var out dataBlob
+	var r, _, err = procCryptProtectData.Call(
+		uintptr(unsafe.Pointer(newDataBlob(plain))),
+		0,
+		uintptr(unsafe.Pointer(newDataBlob(dpapiEntropy))),
+		0,
+		0,
+		cryptProtectUIForbidden,
+		uintptr(unsafe.Pointer(&out)),
+	)
+	if 0 == r {
+		return nil, err
+	}
+
+	return out.bytes(), nil
+}
+
+// Open 解密
+func (c *dpapiCipher) Open(data []byte) ([]byte, error) {
+	var out dataBlob
+	var r, _, err = procCryptUnprotectData.Call(
+		uintptr(unsafe.Pointer(newDataBlob(data))),
+		0,
+		uintptr(unsafe.Pointer(newDataBlob(dpapiEntropy))),
+		0,
+		0,
+		cryptProtectUIForbidden,
+		uintptr(unsafe.Pointer(&out)),
+	)
+	if 0 == r {
+		return nil, err
+	}
+
+	return out.bytes(), nil
+}
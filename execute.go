@@ -1,17 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/clbanning/mxj"
 	"github.com/fsnotify/fsnotify"
 )
@@ -22,28 +31,86 @@ type Message struct {
 	Msg  string      `json:"msg" label:"消息提示内容"`
 	Time string      `json:"time" label:"消息时间"`
 	Data interface{} `json:"data" label:"返回的数据"`
+	More *bool       `json:"has_more" label:"列表是否还有没有返回的数据，服务器没有返回时为 nil"`
+}
+
+// authExpiredCode 服务器接口要求重新登录时返回的消息状态码
+const authExpiredCode = 401
+
+// commandBatchSize 单次从服务器读取的命令数量上限
+const commandBatchSize = 100
+
+// 回执上传编码
+const (
+	UploadJSON = "json"
+	UploadRaw  = "raw"
+	UploadBoth = "both"
+)
+
+// Attachment 随请求以 multipart/form-data 格式上传的文件
+type Attachment struct {
+	Field   string `label:"表单字段名"`
+	Name    string `label:"文件名"`
+	Content []byte `label:"文件内容"`
 }
 
 // Execute 指令执行器
 type Execute struct {
-	client  *Client
-	options *Options
-	mux     *sync.Mutex
-	tip     func(category string, level int, msg ...string)
-	counter func(c *Counter)
-	quit    chan struct{}
-	failed  map[string]int
+	cancel      context.CancelFunc
+	client      *Client
+	options     *Options
+	mux         *sync.Mutex
+	notifier    Notifier
+	store       *Store
+	queue       *Queue
+	ledger      *Ledger
+	loops       *sync.WaitGroup
+	failures    *Failures
+	skewed      int32
+	authMux     sync.Mutex
+	authAt      time.Time
+	tokenExpiry time.Time
+	startAt     time.Time
+	errMux      sync.Mutex
+	lastErr     string
+	lastErrAt   time.Time
 }
 
 // Init 初始化指令执行器
-func (exe *Execute) Init(opt *Options, tip func(category string, level int, msg ...string), c func(c *Counter)) {
-	exe.tip = tip
-	exe.counter = c
+func (exe *Execute) Init(opt *Options, notifier Notifier) {
+	exe.notifier = notifier
 	exe.options = opt
 
 	exe.mux = new(sync.Mutex)
-	exe.failed = make(map[string]int)
-	exe.client = NewClient(exe.options.Debug, tip)
+	exe.client = NewClient(exe.options.Debug, exe.timeout(), notifier)
+
+	if store, err := OpenStore(exe.options.storeFile); nil == err {
+		exe.store = store
+		exe.queue = NewQueue(store)
+		exe.ledger = NewLedger(store)
+		exe.failures = NewFailures(store)
+	} else {
+		exe.notify(EventMessage, LevelFatal, "打开本地存储失败，回执上传失败后将不会重试："+err.Error(), nil)
+	}
+}
+
+// Reload 配置选项修改后重新配置 HTTP 客户端，已登录的会话保持不变
+func (exe *Execute) Reload() {
+	exe.client.Configure(exe.options.Debug, exe.timeout())
+}
+
+// timeout 返回配置的通信超时时间
+func (exe *Execute) timeout() time.Duration {
+	return time.Duration(exe.options.Timeout) * time.Second
+}
+
+// Close 停止业务指令循环后关闭指令执行器占用的资源
+func (exe *Execute) Close() {
+	exe.Stop()
+
+	if nil != exe.store {
+		exe.store.Close()
+	}
 }
 
 // Start 开始业务指令循环
@@ -52,207 +119,424 @@ func (exe *Execute) Start() {
 	defer exe.mux.Unlock()
 
 	if !exe.options.Status {
+		// 每次启动使用新的上下文与通道，业务循环及其发出的请求都使用本次启动的上下文，
+		// 停止后正在进行与之后发起的请求都会取消，上次启动的业务循环不会和本次的循环同时运行
+		var ctx, cancel = context.WithCancel(context.Background())
+		var wake = make(chan struct{}, 1)
+		var loops = new(sync.WaitGroup)
+
 		exe.options.Status = true
-		exe.quit = make(chan struct{})
+		exe.startAt = time.Now()
+		exe.cancel = cancel
+		exe.loops = loops
+		exe.client.Configure(exe.options.Debug, exe.timeout())
+
+		exe.loop(loops, func() { exe.watcher(ctx) })
+		exe.loop(loops, func() { exe.consume(ctx, wake) })
 
-		go exe.watcher()
-		go exe.consume()
+		if exe.options.Push {
+			exe.loop(loops, func() { exe.subscribe(ctx, wake) })
+		}
+
+		if nil != exe.queue {
+			exe.loop(loops, func() { exe.retry(ctx) })
+		}
 	}
 }
 
-// Stop 停止业务指令循环
+// loop 启动一个业务循环，Stop 会等待全部业务循环退出
+func (exe *Execute) loop(loops *sync.WaitGroup, fn func()) {
+	loops.Add(1)
+
+	go func() {
+		defer loops.Done()
+
+		fn()
+	}()
+}
+
+// Stop 停止业务指令循环，等待全部业务循环退出后返回，之后可以安全关闭本地存储
 func (exe *Execute) Stop() {
-	exe.mux.Lock()
-	defer exe.mux.Unlock()
+	var loops *sync.WaitGroup
 
+	exe.mux.Lock()
 	if exe.options.Status {
 		exe.options.Status = false
 
-		exe.quit <- struct{}{}
-		exe.quit <- struct{}{}
+		// 取消上下文通知所有业务循环退出并中止正在进行的请求
+		exe.cancel()
+		loops = exe.loops
+	}
+	exe.mux.Unlock()
 
-		close(exe.quit)
+	// 业务循环会读取 exe.mux 保护的字段，需要释放锁后再等待
+	if nil != loops {
+		loops.Wait()
 	}
 }
 
-// Auth 账号授权检查
-func (exe *Execute) Auth() error {
-	var token string
-	var url = exe.options.URL + "admin/index/login"
-	var doc, err = exe.client.GetDoc(url, nil)
-	if nil == err {
-		doc.Find("form#login-form input").Each(func(i int, s *goquery.Selection) {
-			if n, ok := s.Attr("name"); ok && "__token__" == n {
-				token, _ = s.Attr("value")
-			}
-		})
-	}
+// inboxDirs 返回单一窗口数据目录下全部业务的回执目录
+func (exe *Execute) inboxDirs() []string {
+	var dirs []string
 
-	if "" == token {
-		err = errors.New("读取远程登录表单 token 失败")
-	} else {
-		var flag = true
-		var msg = &Message{}
-		var payload = &ClientPayload{KeepAlive: true, Method: "POST", Data: "__token__=" + token + "&username=" + exe.options.UName + "&password=" + exe.options.Pwd}
-		var header = make(http.Header)
-
-		header.Set("X-Requested-With", "XMLHttpRequest")
-		payload.Header = &header
-		err = exe.client.GetCodec(url, payload, "json", msg)
-		if nil == err && 1 == msg.Code && nil != msg.Data {
-			if v, ok := msg.Data.(map[string]interface{}); ok && nil != v {
-				if id, ok := v["id"].(string); ok && "" != id {
-					exe.options.UID = id
-				} else if id, ok := v["id"].(int64); ok && id > 0 {
-					exe.options.UID = strconv.FormatInt(id, 10)
-				} else if id, ok := v["id"].(float64); ok && int64(id) > 0 {
-					exe.options.UID = strconv.FormatInt(int64(id), 10)
-				} else {
-					flag = false
-				}
-				if ecid, ok := v["ecid"].(string); ok && "" != ecid {
-					exe.options.ECid = ecid
-				} else if ecid, ok := v["ecid"].(int64); ok && ecid > 0 {
-					exe.options.ECid = strconv.FormatInt(ecid, 10)
-				} else if ecid, ok := v["ecid"].(float64); ok && int64(ecid) > 0 {
-					exe.options.ECid = strconv.FormatInt(int64(ecid), 10)
-				} else {
-					flag = false
+	if files, err := ioutil.ReadDir(exe.options.DataPath); nil == err && nil != files {
+		for _, file := range files {
+			if file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
+				var v = exe.options.DataPath + "/" + file.Name() + "/InBox"
+				if IsDir(v) {
+					dirs = append(dirs, v)
 				}
 			}
 		}
-
-		if !flag {
-			err = errors.New("登录失败，请检查用户名与密码后继续")
-		}
 	}
 
-	return err
+	return dirs
 }
 
 // watcher 监视本地指定目录的文件变化事件
-func (exe *Execute) watcher() {
+func (exe *Execute) watcher(ctx context.Context) {
 	var i int
 	var e fsnotify.Event
 	var fw, err = fsnotify.NewWatcher()
 	if nil == err {
-		if files, err := ioutil.ReadDir(exe.options.DataPath); nil == err && nil != files {
-			for _, file := range files {
-				if file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
-					var v = exe.options.DataPath + "/" + file.Name() + "/InBox"
-					if IsDir(v) {
-						i++
-						fw.Add(v)
-					}
-				}
+		defer fw.Close()
+
+		var dirs = exe.inboxDirs()
+		for _, v := range dirs {
+			if nil == fw.Add(v) {
+				i++
 			}
 		}
 
 		if i > 0 {
+			// 先补传程序停止期间收到的回执，扫描期间产生的文件事件由监听缓存后再处理
+			exe.scan(ctx, dirs)
+
 			for {
 				select {
 				case e = <-fw.Events:
-					if IsFile(e.Name) {
-						err = exe.upload(e.Name)
+					if IsFile(e.Name) && strings.HasSuffix(strings.ToLower(e.Name), ".xml") {
+						err = exe.deliver(ctx, e.Name)
+						exe.uploaded(ctx, e.Name, err)
+						exe.notifyCounter()
 					}
 				case err = <-fw.Errors:
-
-				case <-exe.quit:
+					atomic.AddUint64(&exe.options.Counter.Error, 1)
+					exe.notify(EventWatcherError, LevelError, "回执目录监听出错："+err.Error(), nil)
+				case <-ctx.Done():
 					err = ErrFSWatcherStop
 				}
 
 				if err == ErrFSWatcherStop {
 					break
-				} else if nil != err {
-					atomic.AddUint64(&exe.options.Counter.Error, 1)
-					exe.tip("notify", 2, "", "报文处理出错："+err.Error())
-				} else {
-					atomic.AddUint64(&exe.options.Counter.Upload, 1)
 				}
 			}
 		} else {
-			exe.tip("error", 1, "", "没有发现需要监听的目录，请确认选择的单一窗口客户端数据目录是否正确？")
+			exe.notify(EventWatcherError, LevelFatal, "没有发现需要监听的目录，请确认选择的单一窗口客户端数据目录是否正确？", nil)
+		}
+	} else {
+		exe.notify(EventWatcherError, LevelFatal, "创建回执目录监听失败："+err.Error(), nil)
+	}
+}
+
+// scan 扫描回执目录，上传本地记录中没有上传过的回执
+func (exe *Execute) scan(ctx context.Context, dirs []string) {
+	if nil == exe.ledger {
+		return
+	}
+
+	// 首次运行还没有上传记录，早于补传天数的历史回执只记为已上传，以免重复上传全部历史回执
+	var n, skipped int
+	var cutoff time.Time
+	if 0 == exe.ledger.Receipts() && exe.options.ScanDays > 0 {
+		cutoff = time.Now().AddDate(0, 0, -exe.options.ScanDays)
+	}
+	// 停止后不再上传剩余的回执，下次启动时重新扫描
+	for _, dir := range dirs {
+		if files, err := ioutil.ReadDir(dir); nil == err && nil == ctx.Err() {
+			for _, fi := range files {
+				if nil != ctx.Err() {
+					break
+				}
+
+				var file = dir + "/" + fi.Name()
+				if fi.IsDir() || !strings.HasSuffix(strings.ToLower(fi.Name()), ".xml") || exe.ledger.Uploaded(file, fi) {
+					continue
+				} else if fi.ModTime().Before(cutoff) {
+					exe.ledger.MarkUploaded(file)
+					skipped++
+					continue
+				}
+
+				var err = exe.deliver(ctx, file)
+				exe.uploaded(ctx, file, err)
+				n++
+			}
+		}
+	}
+
+	if skipped > 0 {
+		exe.notify(EventMessage, LevelInfo, "首次运行跳过 "+strconv.Itoa(exe.options.ScanDays)+" 天前的历史回执 "+strconv.Itoa(skipped)+" 个，需要时可以使用 resync 命令重新上传", nil)
+	}
+	if n > 0 {
+		exe.notify(EventMessage, LevelInfo, "补传程序停止期间收到的回执 "+strconv.Itoa(n)+" 个", nil)
+		exe.notifyCounter()
+	}
+}
+
+// deliver 回执先写入上传队列再上传，服务器确认接收后从队列移除，上传失败留待重试
+func (exe *Execute) deliver(ctx context.Context, file string) error {
+	var item *QueueItem
+	var content, err = FileGetContents(file)
+	if nil != err {
+		return err
+	}
+
+	if nil != exe.queue {
+		if item, err = exe.queue.Push(file, content); nil != err {
+			item = nil
+			exe.notify(EventMessage, LevelError, "回执写入上传队列失败："+err.Error(), map[string]string{"file": file})
+		}
+	}
+
+	if err = exe.upload(ctx, file, content); nil != item {
+		exe.settle(ctx, item, err)
+	}
+
+	return err
+}
+
+// settle 根据上传结果处理队列项：上传成功或回执内容有误时移出队列，停止导致的失败保持原样，其它失败等待重试
+func (exe *Execute) settle(ctx context.Context, item *QueueItem, err error) {
+	if _, ok := err.(*ReceiptError); nil == err || ok {
+		exe.queue.Done(item)
+	} else if nil != ctx.Err() {
+		exe.queue.Release(item)
+	} else {
+		exe.queue.Fail(item, err)
+	}
+}
+
+// retry 定时重新上传队列中还没有被服务器确认接收的回执
+func (exe *Execute) retry(ctx context.Context) {
+	var t = time.NewTicker(queueRetryMin)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			var items = exe.queue.Due(time.Now())
+			for _, item := range items {
+				if nil != ctx.Err() {
+					exe.queue.Release(item)
+					continue
+				}
+
+				var err = exe.upload(ctx, item.File, item.Content)
+				exe.settle(ctx, item, err)
+				exe.uploaded(ctx, item.File, err)
+			}
+
+			if len(items) > 0 {
+				exe.notifyCounter()
+			}
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
+// uploaded 更新回执上传计数并发送通知，停止导致的失败不计数
+func (exe *Execute) uploaded(ctx context.Context, file string, err error) {
+	if nil != err && nil != ctx.Err() {
+		return
+	} else if nil != err {
+		atomic.AddUint64(&exe.options.Counter.Error, 1)
+		exe.notify(EventReceiptFailed, LevelError, "报文处理出错："+err.Error(), map[string]string{"file": file})
+	} else {
+		if nil != exe.ledger {
+			exe.ledger.MarkUploaded(file)
+		}
+
+		atomic.AddUint64(&exe.options.Counter.Upload, 1)
+		exe.notify(EventReceipt, LevelInfo, "回执上传成功："+file, map[string]string{"file": file})
+	}
+}
+
 // upload 上传回执到远程服务器
-func (exe *Execute) upload(file string) error {
+func (exe *Execute) upload(ctx context.Context, file string, raw []byte) error {
 	var err error
-	var t = strings.Replace(strings.ToLower(file), "\\", "/", -1)
-	if strings.HasSuffix(t, ".xml") {
-		var content []byte
-
-		if content, err = exe.getFile(file); nil == err && nil != content {
-			var v = strings.Split(t, "/")
-			var vv = strings.Split(v[len(v)-1], "_")
-			var param = map[string]string{
-				"ecid":     exe.options.ECid,
-				"admin_id": exe.options.UID,
-				"action":   "result",
-				"file":     v[len(v)-1],
-				"content":  string(content),
+	if strings.HasSuffix(strings.ToLower(file), ".xml") {
+		// 回执转换为 UTF-8 后再解析，原始回执与 sha256 仍使用转换前的字节
+		var utf8 []byte
+		var charset string
+		if utf8, charset, err = ToUTF8(raw); nil != err {
+			return &ReceiptError{Reason: "回执编码无法识别：" + err.Error()}
+		}
+
+		var name = ParseReceiptName(file)
+		var sum = sha256.Sum256(raw)
+		var encoding = exe.options.UploadEncoding
+		var param = map[string]string{
+			"ecid":     exe.options.ECid,
+			"admin_id": exe.options.UID,
+			"action":   name.Action,
+			"business": name.Business,
+			"file":     strings.ToLower(name.File),
+			"encoding": encoding,
+			"charset":  charset,
+			"sha256":   hex.EncodeToString(sum[:]),
+		}
+
+		if "" != name.ID {
+			param["id"] = name.ID
+		}
+		if "" != name.OriginalBN {
+			param["original_bn"] = name.OriginalBN
+		}
+		if "" != name.MessageType {
+			param["message_type"] = name.MessageType
+		}
+
+		// 业务字段提取失败不影响上传，服务器仍可从回执内容中读取
+		if fields, e := ParseReceiptFields(utf8); nil != fields {
+			for k, v := range fields.Param() {
+				param[k] = v
 			}
+			if nil != e {
+				exe.notify(EventMessage, LevelDebug, "提取回执业务字段出错："+e.Error(), map[string]string{"file": file})
+			}
+		}
 
-			if "receipt" == vv[0] {
-				param["id"] = "0"
-				param["action"] = "receipt"
-				param["original_bn"] = vv[1]
-			} else if "successed" == vv[0] || "failed" == vv[0] {
-				param["action"] = "status"
-				param["id"] = strings.Split(strings.Split(v[len(v)-1], ".")[1], "(")[0]
-			} else {
-				param["action"] = "other"
+		if UploadRaw != encoding {
+			var content []byte
+			if content, err = exe.getFile(utf8); nil != err {
+				return &ReceiptError{Reason: "回执内容不是有效的 XML：" + err.Error()}
+			} else if nil == content {
+				return nil
 			}
 
-			err = exe.receipt(param)
+			param["content"] = string(content)
 		}
+
+		// 原始回执按字节上传，服务器可以用 sha256 校验后保存原件
+		var files []*Attachment
+		if UploadJSON != encoding {
+			if exe.options.UploadMultipart {
+				files = append(files, &Attachment{Field: "raw", Name: name.File, Content: raw})
+			} else {
+				param["raw"] = base64.StdEncoding.EncodeToString(raw)
+			}
+		}
+
+		var msg *Message
+		msg, err = exe.receipt(ctx, param, files...)
+		exe.record(&LedgerEntry{Kind: LedgerReceipt, ID: param["id"], Category: param["action"], File: file}, msg, err)
 	}
 
 	return err
 }
 
-// getFileMap 读取回执 XML 文件并解析为 JSON
-func (exe *Execute) getFile(file string) ([]byte, error) {
-	var c, err = FileGetContents(file)
-	if nil == err && nil != c {
-		var m mxj.Map
-		m, err = mxj.NewMapXml(c)
-		if nil == err {
-			return m.Json()
-		}
+// getFile 解析回执 XML 内容为 JSON
+func (exe *Execute) getFile(c []byte) ([]byte, error) {
+	var m, err = mxj.NewMapXml(c)
+	if nil == err {
+		return m.Json()
 	}
 
 	return nil, err
 }
 
-// consume 消费服务器端命令
-func (exe *Execute) consume() {
-	var t = time.NewTicker(time.Duration(exe.options.Interval) * time.Second)
+// consume 消费服务器端命令，启动后立即读取一次，之后按命令数量自适应调整轮询间隔，
+// 推送通道通知有新命令时提前读取
+func (exe *Execute) consume(ctx context.Context, wake <-chan struct{}) {
+	var more bool
+	var count int
+	var delay time.Duration
+	var t = time.NewTimer(0)
 
 	for {
 		select {
+		case <-wake:
+			if !t.Stop() {
+				select {
+				case <-t.C:
+				default:
+				}
+			}
+
+			t.Reset(0)
 		case <-t.C:
-			exe.consumeRemoteCommand()
-		case <-exe.quit:
+			// 连续读取积压的命令时不重复上报运行状态
+			if !more {
+				exe.heartbeat(ctx)
+			}
+
+			count, more = exe.consumeRemoteCommand(ctx)
+			delay = exe.interval(delay, count, more)
+			t.Reset(delay)
+		case <-ctx.Done():
+			t.Stop()
 			return
 		}
 	}
 }
 
-// consumeRemoteCommand 消费服务器端的命令
-func (exe *Execute) consumeRemoteCommand() {
-	var msg = &Message{}
-	var url = exe.options.URL + "api/Chinaport/Commands"
-	var param = map[string]string{"ecid": exe.options.ECid, "admin_id": exe.options.UID}
-	var payload = &ClientPayload{KeepAlive: true, Method: "POST", Data: exe.mapToQS(param)}
-	var err = exe.client.GetCodec(url, payload, "json", msg)
+// interval 计算下次轮询的间隔：本次执行了命令且服务器还有积压的命令时立即读取，执行了命令时使用最短间隔，
+// 没有命令时在上次间隔的基础上加倍，最长不超过配置的轮询间隔
+func (exe *Execute) interval(prev time.Duration, count int, more bool) time.Duration {
+	var max = time.Duration(exe.options.Interval) * time.Second
+	var min = time.Duration(exe.options.MinInterval) * time.Second
+	if min <= 0 || min > max {
+		min = max
+	}
+
+	if more && count > 0 {
+		return 0
+	} else if count > 0 || prev < min {
+		return min
+	} else if prev*2 > max {
+		return max
+	}
+
+	return prev * 2
+}
+
+// heartbeat 向服务器上报运行状态，服务器据此掌握各客户端的运行情况，上报失败不影响命令消费
+func (exe *Execute) heartbeat(ctx context.Context) {
+	var data, err = json.Marshal(exe.Status())
+	if nil == err {
+		var param = map[string]string{"ecid": exe.options.ECid, "admin_id": exe.options.UID, "status": string(data)}
+		var msg *Message
+
+		if msg, err = exe.post(ctx, "Heartbeat", param); nil == err && 0 == msg.Code && "" != msg.Msg {
+			err = errors.New(msg.Msg)
+		}
+	}
+
+	if nil != err && nil == ctx.Err() {
+		exe.notify(EventMessage, LevelDebug, "向远程服务器上报运行状态出错："+err.Error(), nil)
+	}
+}
+
+// consumeRemoteCommand 消费服务器端的命令，按分组交给有限数量的工作协程并发执行，全部执行完成后才返回，
+// 返回实际执行的命令数量，以及服务器是否还有积压的命令：服务器返回了 has_more 时以它为准，
+// 否则在实际执行的数量达到单次上限时认为还有积压，跳过的命令不计入
+func (exe *Execute) consumeRemoteCommand(ctx context.Context) (int, bool) {
+	var count int32
+	var more bool
+	var param = map[string]string{"ecid": exe.options.ECid, "admin_id": exe.options.UID, "limit": strconv.Itoa(commandBatchSize)}
+	var msg, err = exe.post(ctx, "Commands", param)
+
+	if nil != exe.failures && exe.options.Retry.Expire > 0 {
+		exe.failures.Expire(time.Now().Add(-time.Duration(exe.options.Retry.Expire) * time.Hour))
+	}
 
 	if nil == err {
 		if 1 == msg.Code && nil != msg.Data {
 			if rows, ok := msg.Data.([]interface{}); ok && len(rows) > 0 {
-				var category []string
-
+				var keys []string
+				var groups = make(map[string][]*Command)
 				for _, v := range rows {
 					if row, ok := v.(map[string]interface{}); ok {
 						var args = map[string]string{
@@ -261,80 +545,310 @@ func (exe *Execute) consumeRemoteCommand() {
 							"admin_id": exe.options.UID,
 						}
 
-						category = strings.SplitN(row["category"].(string), "|", 2)
-						switch category[0] {
-						case "xml":
-							err = exe.download(args)
-							if nil != err {
-								exe.tip("notify", 3, "", err.Error())
-							}
-						default:
-							err = errors.New("未知的命令：" + row["category"].(string))
-							exe.tip("notify", 4, "", err.Error())
+						// 服务器指定了分组的命令按返回顺序依次执行，没有分组的命令各自独立执行
+						var cmd = NewCommand(row, args)
+						var key = "id:" + cmd.ID
+						if "" != cmd.Group {
+							key = "group:" + cmd.Group
+						}
+						if _, ok := groups[key]; !ok {
+							keys = append(keys, key)
 						}
 
-						if nil != err {
-							atomic.AddUint64(&exe.options.Counter.Error, 1)
+						groups[key] = append(groups[key], cmd)
+					}
+				}
+
+				var wg sync.WaitGroup
+				var sem = make(chan struct{}, exe.workers())
+				// 停止后不再执行剩余的命令，服务器下次仍会返回没有回传状态的命令
+				for _, key := range keys {
+					if nil != ctx.Err() {
+						break
+					}
 
-							// 如果命令执行失败三次，就不要重复执行了并直接上报执行失败，上报成功就清除失败标志
-							exe.failed[args["id"]] = exe.failed[args["id"]] + 1
-							if exe.failed[args["id"]] >= 3 {
-								args["action"] = "download"
-								args["status"] = "failed"
+					wg.Add(1)
+					sem <- struct{}{}
 
-								if err = exe.receipt(args); nil == err {
-									delete(exe.failed, args["id"])
-								}
+					go func(cmds []*Command) {
+						defer func() {
+							<-sem
+							wg.Done()
+						}()
+
+						for _, cmd := range cmds {
+							if nil != ctx.Err() {
+								break
+							} else if exe.execute(ctx, cmd) {
+								atomic.AddInt32(&count, 1)
 							}
-						} else {
-							atomic.AddUint64(&exe.options.Counter.Download, 1)
 						}
-					}
+					}(groups[key])
 				}
 
-				exe.counter(exe.options.Counter)
+				wg.Wait()
+				exe.notifyCounter()
 			} else {
-				exe.tip("notify", 4, "", "从远程服务器读取的命令列表为空")
+				exe.notify(EventMessage, LevelDebug, "从远程服务器读取的命令列表为空", nil)
 			}
 		} else if 0 == msg.Code && "" != msg.Msg {
-			exe.tip("notify", 2, "", "从远程服务器获取命令出错："+msg.Msg)
+			exe.notify(EventMessage, LevelError, "从远程服务器获取命令出错："+msg.Msg, nil)
 		}
+	} else if nil == ctx.Err() {
+		exe.notify(EventMessage, LevelError, "从远程服务器获取命令出错："+err.Error(), nil)
+	}
+
+	if nil != msg && nil != msg.More {
+		more = *msg.More
 	} else {
-		exe.tip("notify", 2, "", "从远程服务器获取命令出错："+err.Error())
+		more = count >= commandBatchSize
 	}
+
+	return int(count), more
 }
 
-// receipt 状态回传
-func (exe *Execute) receipt(param map[string]string) error {
+// execute 执行一条远程命令，失败时按重试策略记录失败次数并决定是否向服务器上报执行失败，
+// 返回是否执行了命令，已不再执行、还没到下次执行时间或因停止而中断时返回 false，中断不计入失败次数
+func (exe *Execute) execute(ctx context.Context, cmd *Command) bool {
+	var rec *FailureRecord
+	var policy = exe.options.Retry
+	if nil != exe.failures {
+		rec = exe.failures.Get(cmd.ID)
+	}
+
+	// 之前失败过的命令不再执行时只补报失败，还没到下次执行时间的本次跳过
+	if nil != rec && (rec.Exhausted || time.Now().Before(rec.Next)) {
+		if rec.Exhausted && !rec.Reported && ReportNever != policy.Report {
+			exe.reportFailed(ctx, cmd, rec)
+		}
+
+		return false
+	}
+
+	var err = exe.dispatch(ctx, cmd)
+	if nil != err && nil != ctx.Err() {
+		return false
+	} else if nil != err {
+		if _, ok := commandHandlers[cmd.Name]; ok {
+			exe.notify(EventCommandFailed, LevelInfo, err.Error(), cmd.Param)
+		} else {
+			exe.notify(EventCommandFailed, LevelDebug, err.Error(), cmd.Param)
+		}
+	}
+
+	exe.record(&LedgerEntry{Kind: LedgerCommand, ID: cmd.ID, Category: cmd.Category}, nil, err)
+
+	if nil == err {
+		if nil != rec {
+			exe.failures.Done(cmd.ID)
+		}
+
+		return true
+	}
+
+	atomic.AddUint64(&exe.options.Counter.Error, 1)
+
+	if nil != exe.failures {
+		rec, _ = exe.failures.Fail(cmd, err, &policy)
+	} else {
+		// 本地存储不可用时无法记录失败次数，直接按不再执行处理
+		rec = &FailureRecord{ID: cmd.ID, Category: cmd.Category, Attempts: 1, Error: err.Error(), Exhausted: true}
+	}
+
+	if rec.Exhausted && ReportNever != policy.Report {
+		exe.reportFailed(ctx, cmd, rec)
+	}
+
+	return true
+}
+
+// reportFailed 向服务器上报命令执行失败，上报成功后记录已上报，以免重启后重复上报
+func (exe *Execute) reportFailed(ctx context.Context, cmd *Command, rec *FailureRecord) {
+	var args = make(map[string]string, len(cmd.Param)+3)
+	for k, v := range cmd.Param {
+		args[k] = v
+	}
+
+	args["action"] = cmd.Action()
+	args["status"] = "failed"
+	args["reason"] = rec.Error
+
+	var msg, err = exe.receipt(ctx, args)
+	exe.record(&LedgerEntry{Kind: LedgerCommand, ID: cmd.ID, Category: "report"}, msg, err)
+	if nil == err && nil != exe.failures {
+		rec.Reported = true
+		exe.failures.Put(rec)
+	}
+}
+
+// workers 返回并发执行远程命令的工作协程数量
+func (exe *Execute) workers() int {
+	if exe.options.Workers > 0 {
+		return exe.options.Workers
+	}
+
+	return 1
+}
+
+// post 调用服务器 api/Chinaport 接口，登录会话过期时自动重新登录后重发请求
+func (exe *Execute) post(ctx context.Context, api string, param map[string]string, files ...*Attachment) (*Message, error) {
+	var err error
+	var msg *Message
+	var start = time.Now()
+	if exe.tokenExpired() {
+		err = exe.reauth(ctx, start)
+	}
+	if nil == err {
+		msg, err = exe.request(ctx, api, param, files...)
+	}
+	if ErrAuthExpired == err {
+		if err = exe.reauth(ctx, start); nil == err {
+			msg, err = exe.request(ctx, api, param, files...)
+		}
+	}
+
+	return msg, err
+}
+
+// request 调用服务器 api/Chinaport 接口，配置了 Token 时对请求参数签名并检查响应时间，
+// 附带文件时以 multipart/form-data 格式提交，签名只包含普通参数
+func (exe *Execute) request(ctx context.Context, api string, param map[string]string, files ...*Attachment) (*Message, error) {
 	var msg = &Message{}
-	var url = exe.options.URL + "api/Chinaport/Receipt"
-	var payload = &ClientPayload{KeepAlive: true, Method: "POST", Data: exe.mapToQS(param)}
-	var err = exe.client.GetCodec(url, payload, "json", msg)
+	var url = exe.options.URL + "api/Chinaport/" + api
+	var token = exe.options.Token
+	var clock = exe.client.Clock()
+	if "" != token {
+		param = SignParam(token, clock.Now(), param)
+	}
+
+	// 下载接口直接返回报文内容，读取响应体的时间放宽到普通接口的 6 倍
+	var deadline = exe.client.Deadline()
+	if "Download" == api {
+		deadline.Body = deadline.Body * 6
+	}
+
+	var sent = time.Now()
+	var err error
+	var payload = &ClientPayload{KeepAlive: true, Method: "POST", Data: exe.mapToQS(param), Deadline: &deadline}
+	if len(files) > 0 {
+		var contentType string
+		if payload.Data, contentType, err = exe.multipart(param, files); nil != err {
+			return msg, err
+		}
+
+		payload.Header = &http.Header{"Content-Type": []string{contentType}}
+	}
+
+	err = exe.client.GetCodec(ctx, url, payload, "json", msg)
+	var received = time.Now()
+	if nil == err && authExpiredCode == msg.Code {
+		err = ErrAuthExpired
+	}
+
+	// 每个响应取一个时钟样本，有消息时间时使用消息时间，否则使用响应头 Date，不论是否签名；
+	// 先更新偏差估算再按校正后的时间做时差校验，本机时间偏差超过最大时差时签名请求也能正常工作，
+	// 偏差取最近样本的中位数，个别重放的旧响应不会影响估算
+	var server = payload.Date
+	if t, e := ParseMessageTime(msg.Time); nil == e {
+		server = t
+	}
+
+	var offset = clock.Offset()
+	if !server.IsZero() {
+		offset = clock.Observe(server, sent, received)
+	}
+
+	if nil == err && "" != token {
+		err = CheckMessageTime(msg, clock.Now(), time.Duration(exe.options.TimeLag)*time.Second)
+	}
+
+	exe.checkSkew(offset)
+
+	return msg, err
+}
+
+// checkSkew 本机与服务器时间偏差超过最大时差时发出警告，恢复正常后再次超出才重新警告
+func (exe *Execute) checkSkew(offset time.Duration) {
+	var lag = time.Duration(exe.options.TimeLag) * time.Second
+	if offset > lag || offset < -lag {
+		if atomic.CompareAndSwapInt32(&exe.skewed, 0, 1) {
+			exe.notify(EventClockSkew, LevelError, "本机时间与服务器相差 "+offset.String()+"，超过了最大时差，请校准电脑时间", map[string]string{"offset": offset.String()})
+		}
+	} else {
+		atomic.StoreInt32(&exe.skewed, 0)
+	}
+}
+
+// multipart 生成 multipart/form-data 格式的请求体，返回请求体与 Content-Type
+func (exe *Execute) multipart(param map[string]string, files []*Attachment) (*bytes.Buffer, string, error) {
+	var err error
+	var buf = new(bytes.Buffer)
+	var w = multipart.NewWriter(buf)
+
+	for k, v := range param {
+		if err = w.WriteField(k, v); nil != err {
+			return nil, "", err
+		}
+	}
+
+	for _, f := range files {
+		var part io.Writer
+		if part, err = w.CreateFormFile(f.Field, f.Name); nil == err {
+			_, err = part.Write(f.Content)
+		}
+		if nil != err {
+			return nil, "", err
+		}
+	}
+
+	return buf, w.FormDataContentType(), w.Close()
+}
+
+// receipt 状态回传，返回服务器响应消息
+func (exe *Execute) receipt(ctx context.Context, param map[string]string, files ...*Attachment) (*Message, error) {
+	var msg, err = exe.post(ctx, "Receipt", param, files...)
 
 	if nil == err && 0 == msg.Code {
 		err = errors.New(msg.Msg)
 	}
 
-	return err
+	return msg, err
 }
 
 // download 下载数据
-func (exe *Execute) download(param map[string]string) error {
-	var msg = &Message{}
-	var url = exe.options.URL + "api/Chinaport/Download"
-	var payload = &ClientPayload{KeepAlive: true, Method: "POST", Data: exe.mapToQS(param)}
-	var err = exe.client.GetCodec(url, payload, "json", msg)
+func (exe *Execute) download(ctx context.Context, param map[string]string) error {
+	var msg, err = exe.post(ctx, "Download", param)
 
 	if nil == err {
 		if 1 == msg.Code && nil != msg.Data {
 			if data, ok := msg.Data.(map[string]interface{}); ok && nil != data {
-				var file = exe.options.DataPath + "/" + data["path"].(string)
-				err = FilePutContents(file, []byte(data["xml"].(string)), false)
+				var file string
+				var p, _ = data["path"].(string)
+				var content, _ = data["xml"].(string)
+				var raw []byte
+				if file, err = exe.safePath(p, exe.options.AllowPaths); nil == err {
+					// 校验不通过的报文单一窗口客户端无法导入，不需要重试，直接上报失败原因
+					if e := ValidateXML([]byte(content), exe.options.ValidateRules); nil != e {
+						err = &CommandError{Reason: "报文校验失败：" + e.Error()}
+					} else {
+						raw, err = FromUTF8([]byte(content), exe.options.OutputEncoding)
+					}
+				}
+				if nil == err {
+					err = FileAtomicPutContents(file, raw)
+				}
 				if nil == err {
 					param["action"] = "download"
 					param["status"] = "ok"
 
-					err = exe.receipt(param)
+					var receipt *Message
+					if receipt, err = exe.receipt(ctx, param); nil == err {
+						exe.notify(EventDownload, LevelInfo, "报文下载成功："+file, map[string]string{"id": param["id"], "file": file})
+					} else {
+						exe.rollback(file, err)
+					}
+
+					exe.record(&LedgerEntry{Kind: LedgerDownload, ID: param["id"], File: file}, receipt, err)
 				}
 			}
 		} else {
@@ -349,6 +863,92 @@ func (exe *Execute) download(param map[string]string) error {
 	return err
 }
 
+// record 写入传输记录，根据错误设置处理状态
+func (exe *Execute) record(e *LedgerEntry, msg *Message, err error) {
+	if nil == exe.ledger {
+		return
+	}
+
+	e.Status = "ok"
+	if nil != err {
+		e.Status = "failed"
+		e.Error = err.Error()
+	}
+	if nil != msg {
+		e.Response = msg.Msg
+	}
+
+	exe.ledger.Record(e)
+}
+
+// notify 发送事件通知
+func (exe *Execute) notify(kind EventKind, level int, msg string, data map[string]string) {
+	if level <= LevelError {
+		exe.errMux.Lock()
+		exe.lastErr, exe.lastErrAt = msg, time.Now()
+		exe.errMux.Unlock()
+	}
+
+	if nil != exe.notifier {
+		exe.notifier.Notify(NewEvent(kind, level, msg, data))
+	}
+}
+
+// notifyCounter 发送计数器更新通知
+func (exe *Execute) notifyCounter() {
+	if nil != exe.notifier {
+		var e = NewEvent(EventCounter, LevelInfo, "", nil)
+		e.Counter = exe.counter()
+
+		exe.notifier.Notify(e)
+	}
+}
+
+// inboxPaths 回执所在的单一窗口子目录
+var inboxPaths = []string{"*/InBox"}
+
+// safePath 把服务器下发的相对路径限制在单一窗口数据目录内允许访问的子目录中，
+// 绝对路径、跳出数据目录或不在允许目录中的路径都直接拒绝
+func (exe *Execute) safePath(p string, allow []string) (string, error) {
+	var rel = path.Clean(strings.Replace(p, "\\", "/", -1))
+	if "" == p || "." == rel || path.IsAbs(rel) || strings.Contains(rel, ":") || ".." == rel || strings.HasPrefix(rel, "../") {
+		return "", &CommandError{Reason: "服务器下发的报文路径不合法：" + p}
+	}
+
+	var dir = strings.ToLower(path.Dir(rel))
+	for _, v := range allow {
+		if ok, err := path.Match(strings.ToLower(v), dir); ok && nil == err {
+			return exe.options.DataPath + "/" + rel, nil
+		}
+	}
+
+	return "", &CommandError{Reason: "服务器下发的报文路径不在允许访问的目录中：" + p}
+}
+
+// rollback 下载状态回传失败时删除已写入的报文，保持本地目录与服务器状态一致，
+// 删除失败时重命名为 .failed 文件，以免单一窗口客户端导入服务器认为没有下载的报文
+func (exe *Execute) rollback(file string, cause error) {
+	var err = os.Remove(file)
+	if nil != err && !os.IsNotExist(err) {
+		err = os.Rename(file, file+".failed")
+	}
+
+	if nil != err && !os.IsNotExist(err) {
+		exe.notify(EventCommandFailed, LevelError, "回滚下载的报文失败："+file+"，"+err.Error(), map[string]string{"file": file})
+	} else {
+		exe.notify(EventCommandFailed, LevelInfo, "下载状态回传失败，已回滚报文："+file+"，"+cause.Error(), map[string]string{"file": file})
+	}
+}
+
+// counter 返回计数器快照
+func (exe *Execute) counter() *Counter {
+	return &Counter{
+		Error:    atomic.LoadUint64(&exe.options.Counter.Error),
+		Upload:   atomic.LoadUint64(&exe.options.Counter.Upload),
+		Download: atomic.LoadUint64(&exe.options.Counter.Download),
+	}
+}
+
 // mapToQS 将 map 结构的参数转换为 form 表单字符串形式
 func (exe *Execute) mapToQS(data map[string]string) string {
 	var v = make(url.Values)
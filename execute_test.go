@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestExecuteInterval 轮询间隔按执行的命令数量与积压情况调整，没有执行命令时不会立即再次读取
+func TestExecuteInterval(t *testing.T) {
+	var exe = &Execute{options: &Options{Interval: 60, MinInterval: 5}}
+	var s = time.Second
+
+	var cases = []struct {
+		prev  time.Duration
+		count int
+		more  bool
+		want  time.Duration
+	}{
+		{0, 100, true, 0},
+		{0, 0, true, 5 * s},
+		{40 * s, 0, true, 60 * s},
+		{0, 3, false, 5 * s},
+		{20 * s, 3, false, 5 * s},
+		{0, 0, false, 5 * s},
+		{5 * s, 0, false, 10 * s},
+		{40 * s, 0, false, 60 * s},
+	}
+
+	for _, c := range cases {
+		if got := exe.interval(c.prev, c.count, c.more); c.want != got {
+			t.Errorf("interval(%v, %d, %v) = %v，应为 %v", c.prev, c.count, c.more, got, c.want)
+		}
+	}
+}
+
+// TestRequestClockSkew 服务器时间超前时根据消息时间或响应头 Date 估算偏差，签名请求的时差校验按校正后的时间进行
+func TestRequestClockSkew(t *testing.T) {
+	var cases = []struct {
+		name    string
+		token   string
+		skew    time.Duration
+		msgTime bool
+	}{
+		{"签名请求超出最大时差", "secret", 10 * time.Minute, true},
+		{"没有签名只有响应头 Date", "", time.Hour, false},
+	}
+
+	for _, c := range cases {
+		var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var now = time.Now().Add(c.skew)
+			var msg = &Message{Code: 1}
+			if c.msgTime {
+				msg.Time = strconv.FormatInt(now.Unix(), 10)
+			}
+
+			w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+			json.NewEncoder(w).Encode(msg)
+		}))
+
+		var exe = newTestExecute(srv.URL)
+		exe.options.Token = c.token
+		exe.options.TimeLag = 300
+
+		for i := 0; i < 3; i++ {
+			if _, err := exe.request(context.Background(), "Commands", map[string]string{}); nil != err {
+				t.Errorf("%s：第 %d 次请求出错 %v", c.name, i+1, err)
+			}
+		}
+
+		if d := exe.client.Clock().Offset() - c.skew; d > 2*time.Second || d < -2*time.Second {
+			t.Errorf("%s：估算的偏差为 %v，应为 %v", c.name, exe.client.Clock().Offset(), c.skew)
+		}
+
+		var warned int
+		for _, e := range exe.notifier.(*Recorder).Events() {
+			if EventClockSkew == e.Kind {
+				warned++
+			}
+		}
+		if 1 != warned {
+			t.Errorf("%s：时间偏差警告 %d 次，应为 1 次", c.name, warned)
+		}
+
+		srv.Close()
+	}
+}
+
+// TestExecuteCanceled 停止导致的命令中断不记录失败、不计数也不发出失败通知
+func TestExecuteCanceled(t *testing.T) {
+	var l, clean = openTestLedger(t)
+	defer clean()
+
+	RegisterCommand("test-wait", func(ctx context.Context, exe *Execute, cmd *Command) error {
+		<-ctx.Done()
+
+		return ctx.Err()
+	})
+	defer delete(commandHandlers, "test-wait")
+
+	var r = new(Recorder)
+	var exe = &Execute{options: &Options{Counter: new(Counter)}, notifier: r, ledger: l, failures: NewFailures(l.store)}
+	exe.options.Retry.Init()
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	var cmd = NewCommand(map[string]interface{}{"category": "test-wait"}, map[string]string{"id": "1"})
+	if exe.execute(ctx, cmd) {
+		t.Error("中断的命令不应计为已执行")
+	}
+	if nil != exe.failures.Get("1") {
+		t.Error("中断的命令不应记录失败")
+	}
+	if 0 != exe.options.Counter.Error {
+		t.Errorf("错误计数为 %d，应为 0", exe.options.Counter.Error)
+	}
+	if _, ok := l.Command("1"); ok {
+		t.Error("中断的命令不应写入传输记录")
+	}
+	for _, e := range r.Events() {
+		if EventCommandFailed == e.Kind {
+			t.Errorf("中断的命令发出了失败通知：%s", e.Message)
+		}
+	}
+}
+
+// TestSafePath 服务器下发的路径只能落在数据目录内允许访问的子目录中
+func TestSafePath(t *testing.T) {
+	var exe = &Execute{options: &Options{DataPath: "C:/ImpPath"}}
+	var allow = []string{"*/OutBox"}
+
+	var cases = []struct {
+		path string
+		want string
+	}{
+		{"DecCus001/OutBox/a.xml", "C:/ImpPath/DecCus001/OutBox/a.xml"},
+		{`DecCus001\OutBox\a.xml`, "C:/ImpPath/DecCus001/OutBox/a.xml"},
+		{"deccus001/outbox/a.xml", "C:/ImpPath/deccus001/outbox/a.xml"},
+		{"DecCus001/InBox/../OutBox/a.xml", "C:/ImpPath/DecCus001/OutBox/a.xml"},
+		{"./DecCus001/OutBox/a.xml", "C:/ImpPath/DecCus001/OutBox/a.xml"},
+		{"", ""},
+		{".", ""},
+		{"..", ""},
+		{"../a.xml", ""},
+		{"../DecCus001/OutBox/a.xml", ""},
+		{`..\DecCus001\OutBox\a.xml`, ""},
+		{"a/OutBox/../../x", ""},
+		{"a/OutBox/../../../x/OutBox/a.xml", ""},
+		{"/DecCus001/OutBox/a.xml", ""},
+		{`\DecCus001\OutBox\a.xml`, ""},
+		{`\\server\share\OutBox\a.xml`, ""},
+		{"C:/ImpPath/DecCus001/OutBox/a.xml", ""},
+		{`C:\ImpPath\DecCus001\OutBox\a.xml`, ""},
+		{"C:DecCus001/OutBox/a.xml", ""},
+		{"DecCus001/OutBox/sub/a.xml", ""},
+		{"DecCus001/InBox/a.xml", ""},
+		{"DecCus001/a.xml", ""},
+		{"a.xml", ""},
+		{"DecCus001/OutBox", ""},
+	}
+
+	for _, c := range cases {
+		var got, err = exe.safePath(c.path, allow)
+		if "" == c.want {
+			if _, ok := err.(*CommandError); !ok || "" != got {
+				t.Errorf("%q：应拒绝，返回 %q %v", c.path, got, err)
+			}
+		} else if nil != err || c.want != got {
+			t.Errorf("%q：返回 %q %v，应为 %q", c.path, got, err, c.want)
+		}
+	}
+
+	if got, err := exe.safePath("DecCus001/InBox/a.xml", inboxPaths); nil != err || "C:/ImpPath/DecCus001/InBox/a.xml" != got {
+		t.Errorf("回执目录应允许访问：%q %v", got, err)
+	}
+}
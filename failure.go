@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 命令失败上报策略
+const (
+	ReportExhausted = "exhausted"
+	ReportNever     = "never"
+)
+
+// RetryPolicy 远程命令执行失败后的重试策略
+type RetryPolicy struct {
+	MaxAttempts int    `json:"max_attempts" label:"最多执行次数，达到后不再执行"`
+	Backoff     int    `json:"backoff" label:"失败后再次执行的初始等待秒数，之后每次失败加倍"`
+	MaxBackoff  int    `json:"max_backoff" label:"失败后再次执行的最长等待秒数"`
+	Report      string `json:"report" label:"何时向服务器上报执行失败：exhausted 不再执行时上报，never 不上报"`
+	Expire      int    `json:"expire" label:"失败记录的保留小时数，过期后命令可以重新执行"`
+}
+
+// Init 补全没有配置的重试策略
+func (p *RetryPolicy) Init() {
+	if 0 == p.MaxAttempts {
+		p.MaxAttempts = 3
+	}
+
+	if 0 == p.Backoff {
+		p.Backoff = 60
+	}
+
+	if 0 == p.MaxBackoff {
+		p.MaxBackoff = 3600
+	}
+
+	if "" == p.Report {
+		p.Report = ReportExhausted
+	}
+
+	if 0 == p.Expire {
+		p.Expire = 72
+	}
+}
+
+// Delay 返回第 attempts 次失败后的等待时间
+func (p *RetryPolicy) Delay(attempts int) time.Duration {
+	var delay = time.Duration(p.Backoff) * time.Second
+	var max = time.Duration(p.MaxBackoff) * time.Second
+	for i := 1; i < attempts && delay < max; i++ {
+		delay = delay * 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	return delay
+}
+
+// FailureRecord 远程命令失败记录
+type FailureRecord struct {
+	ID        string    `json:"id" label:"命令 ID"`
+	Category  string    `json:"category" label:"命令类型"`
+	Attempts  int       `json:"attempts" label:"已执行次数"`
+	Error     string    `json:"error" label:"最后一次执行错误"`
+	Exhausted bool      `json:"exhausted" label:"是否已不再执行"`
+	Reported  bool      `json:"reported" label:"是否已向服务器上报执行失败"`
+	Next      time.Time `json:"next" label:"下次执行时间"`
+	First     time.Time `json:"first" label:"第一次失败时间"`
+	Last      time.Time `json:"last" label:"最后一次失败时间"`
+}
+
+// NewFailures 创建远程命令失败记录
+func NewFailures(store *Store) *Failures {
+	return &Failures{store: store, bucket: "failures"}
+}
+
+// Failures 持久化的远程命令失败记录，程序重启后保留失败次数与上报状态
+type Failures struct {
+	store  *Store `label:"本地存储"`
+	bucket string `label:"存储桶名称"`
+}
+
+// Get 读取命令的失败记录，没有记录时返回 nil
+func (f *Failures) Get(id string) *FailureRecord {
+	var rec = new(FailureRecord)
+	if ok, err := f.store.Get(f.bucket, id, rec); nil != err || !ok {
+		return nil
+	}
+
+	return rec
+}
+
+// Fail 记录一次执行失败，按重试策略计算下次执行时间，不需要重试的错误或次数用完时标记为不再执行
+func (f *Failures) Fail(cmd *Command, err error, policy *RetryPolicy) (*FailureRecord, error) {
+	var now = time.Now()
+	var rec = f.Get(cmd.ID)
+	if nil == rec {
+		rec = &FailureRecord{ID: cmd.ID, Category: cmd.Category, First: now}
+	}
+
+	rec.Attempts++
+	rec.Error = err.Error()
+	rec.Last = now
+	rec.Next = now.Add(policy.Delay(rec.Attempts))
+	switch err.(type) {
+	case *CommandError, *ReceiptError:
+		rec.Exhausted = true
+	default:
+		rec.Exhausted = rec.Attempts >= policy.MaxAttempts
+	}
+
+	return rec, f.store.Put(f.bucket, cmd.ID, rec)
+}
+
+// Put 保存失败记录
+func (f *Failures) Put(rec *FailureRecord) error {
+	return f.store.Put(f.bucket, rec.ID, rec)
+}
+
+// Done 命令执行成功，删除失败记录
+func (f *Failures) Done(id string) error {
+	return f.store.Delete(f.bucket, id)
+}
+
+// Expire 删除第一次失败时间早于 before 的记录
+func (f *Failures) Expire(before time.Time) error {
+	var keys []string
+
+	f.store.Each(f.bucket, func(key string, data []byte) error {
+		var rec = new(FailureRecord)
+		if err := json.Unmarshal(data, rec); nil != err || rec.First.Before(before) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+
+	for _, key := range keys {
+		if err := f.store.Delete(f.bucket, key); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
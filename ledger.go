@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ReceiptRecord 已上传回执记录
+type ReceiptRecord struct {
+	File     string    `json:"file" label:"回执文件路径"`
+	Size     int64     `json:"size" label:"文件大小"`
+	ModTime  time.Time `json:"mod_time" label:"文件修改时间"`
+	Uploaded time.Time `json:"uploaded" label:"上传时间"`
+}
+
+// NewLedger 创建本地传输记录
+func NewLedger(store *Store) *Ledger {
+	return &Ledger{store: store}
+}
+
+// Ledger 本地传输记录
+type Ledger struct {
+	store *Store `label:"本地存储"`
+}
+
+// Uploaded 检查回执文件是否已上传，文件大小或修改时间变化视为未上传
+func (l *Ledger) Uploaded(file string, fi os.FileInfo) bool {
+	var r = new(ReceiptRecord)
+	if ok, err := l.store.Get("receipts", l.key(file), r); ok && nil == err {
+		return r.Size == fi.Size() && r.ModTime.Equal(fi.ModTime())
+	}
+
+	return false
+}
+
+// MarkUploaded 记录回执文件已上传
+func (l *Ledger) MarkUploaded(file string) error {
+	var r = &ReceiptRecord{
+		File:     file,
+		Uploaded: time.Now(),
+	}
+
+	if fi, err := os.Stat(file); nil == err {
+		r.Size = fi.Size()
+		r.ModTime = fi.ModTime()
+	}
+
+	return l.store.Put("receipts", l.key(file), r)
+}
+
+// Receipts 返回已上传回执记录数
+func (l *Ledger) Receipts() int {
+	return l.store.Count("receipts")
+}
+
+// key 统一文件路径格式作为记录键
+func (l *Ledger) key(file string) string {
+	return strings.Replace(filepath.Clean(file), "\\", "/", -1)
+}
+
+// 传输记录类型
+const (
+	LedgerCommand  = "command"  // 远程命令执行结果
+	LedgerDownload = "download" // 报文下载到单一窗口目录
+	LedgerReceipt  = "receipt"  // 回执上传到服务器
+)
+
+// errLedgerStop 查询记录达到数量限制时停止遍历
+var errLedgerStop = errors.New("ledger query limit reached")
+
+// LedgerEntry 传输记录
+type LedgerEntry struct {
+	Kind     string    `json:"kind" label:"记录类型"`
+	ID       string    `json:"id,omitempty" label:"远程命令 ID"`
+	Category string    `json:"category,omitempty" label:"远程命令类型"`
+	File     string    `json:"file,omitempty" label:"本地文件路径"`
+	Status   string    `json:"status" label:"处理状态"`
+	Response string    `json:"response,omitempty" label:"服务器返回消息"`
+	Error    string    `json:"error,omitempty" label:"错误信息"`
+	Time     time.Time `json:"time" label:"记录时间"`
+}
+
+// LedgerQuery 传输记录查询条件，零值字段不参与过滤
+type LedgerQuery struct {
+	Kind   string    `label:"记录类型"`
+	ID     string    `label:"远程命令 ID"`
+	File   string    `label:"本地文件路径"`
+	Status string    `label:"处理状态"`
+	Since  time.Time `label:"开始时间（含）"`
+	Until  time.Time `label:"结束时间（不含）"`
+	Limit  int       `label:"最多返回条数"`
+}
+
+// Record 追加一条传输记录，远程命令同时保存为按 ID 查询的最新状态
+func (l *Ledger) Record(e *LedgerEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	var err = l.store.Append("history", l.timeKey(e.Time), e)
+	if nil == err && LedgerCommand == e.Kind && "" != e.ID {
+		err = l.store.Put("commands", e.ID, e)
+	}
+
+	return err
+}
+
+// Command 返回远程命令的最新执行记录
+func (l *Ledger) Command(id string) (*LedgerEntry, bool) {
+	var e = new(LedgerEntry)
+	if ok, err := l.store.Get("commands", id, e); ok && nil == err {
+		return e, true
+	}
+
+	return nil, false
+}
+
+// Query 按时间顺序查询传输记录
+func (l *Ledger) Query(q *LedgerQuery) []*LedgerEntry {
+	var from, to string
+	var ret []*LedgerEntry
+
+	if !q.Since.IsZero() {
+		from = l.timeKey(q.Since)
+	}
+	if !q.Until.IsZero() {
+		to = l.timeKey(q.Until)
+	}
+
+	l.store.Range("history", from, to, func(key string, data []byte) error {
+		var e = new(LedgerEntry)
+		if err := json.Unmarshal(data, e); nil != err {
+			return nil
+		}
+
+		if ("" == q.Kind || q.Kind == e.Kind) && ("" == q.ID || q.ID == e.ID) && ("" == q.File || l.key(q.File) == l.key(e.File)) && ("" == q.Status || q.Status == e.Status) {
+			ret = append(ret, e)
+			if q.Limit > 0 && len(ret) >= q.Limit {
+				return errLedgerStop
+			}
+		}
+
+		return nil
+	})
+
+	return ret
+}
+
+// timeKey 把时间转换为可按字典序排序的记录键前缀
+func (l *Ledger) timeKey(t time.Time) string {
+	return t.UTC().Format("20060102150405.000000000")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestStore 在临时目录中创建本地存储，返回清理函数
+func openTestStore(t *testing.T) (*Store, func()) {
+	var dir, err = ioutil.TempDir("", "swa")
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	var store *Store
+	if store, err = OpenStore(filepath.Join(dir, "swa.db")); nil != err {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// openTestLedger 在临时目录中创建传输记录，返回清理函数
+func openTestLedger(t *testing.T) (*Ledger, func()) {
+	var store, clean = openTestStore(t)
+
+	return NewLedger(store), clean
+}
+
+// TestLedgerQuery 按类型、状态、文件、时间范围与条数查询传输记录
+func TestLedgerQuery(t *testing.T) {
+	var l, clean = openTestLedger(t)
+	defer clean()
+
+	var base = time.Date(2018, 6, 1, 8, 0, 0, 0, time.Local)
+	var entries = []*LedgerEntry{
+		{Kind: LedgerCommand, ID: "1", Category: "xml", Status: "failed", Time: base},
+		{Kind: LedgerDownload, ID: "1", File: "C:/ImpPath/DecCus001/OutBox/a.xml", Status: "ok", Time: base.Add(time.Hour)},
+		{Kind: LedgerCommand, ID: "1", Category: "xml", Status: "ok", Time: base.Add(2 * time.Hour)},
+		{Kind: LedgerReceipt, File: "C:/ImpPath/DecCus001/InBox/Receipt_1.xml", Status: "ok", Time: base.AddDate(0, 0, 1)},
+	}
+	for _, e := range entries {
+		if err := l.Record(e); nil != err {
+			t.Fatal(err)
+		}
+	}
+
+	var cases = []struct {
+		name string
+		q    *LedgerQuery
+		want []*LedgerEntry
+	}{
+		{"全部", &LedgerQuery{}, entries},
+		{"类型", &LedgerQuery{Kind: LedgerCommand}, []*LedgerEntry{entries[0], entries[2]}},
+		{"状态", &LedgerQuery{Kind: LedgerCommand, Status: "failed"}, entries[:1]},
+		{"命令 ID", &LedgerQuery{ID: "1"}, entries[:3]},
+		{"文件不区分分隔符", &LedgerQuery{File: `C:\ImpPath\DecCus001\OutBox\a.xml`}, entries[1:2]},
+		{"时间范围", &LedgerQuery{Since: base.Add(time.Hour), Until: base.AddDate(0, 0, 1)}, entries[1:3]},
+		{"条数", &LedgerQuery{Limit: 2}, entries[:2]},
+	}
+
+	for _, c := range cases {
+		var got = l.Query(c.q)
+		if len(got) != len(c.want) {
+			t.Errorf("%s：返回 %d 条记录，应为 %d 条", c.name, len(got), len(c.want))
+			continue
+		}
+
+		for i := range got {
+			if got[i].Kind != c.want[i].Kind || got[i].Status != c.want[i].Status || !got[i].Time.Equal(c.want[i].Time) {
+				t.Errorf("%s：第 %d 条记录为 %+v，应为 %+v", c.name, i, got[i], c.want[i])
+			}
+		}
+	}
+
+	if e, ok := l.Command("1"); !ok || "ok" != e.Status || !e.Time.Equal(entries[2].Time) {
+		t.Errorf("命令最新执行记录不正确：%+v", e)
+	}
+	if _, ok := l.Command("2"); ok {
+		t.Errorf("不存在的命令不应有执行记录")
+	}
+}
+
+// TestParseLedgerQuery 传输记录命令参数转换为查询条件
+func TestParseLedgerQuery(t *testing.T) {
+	var now = time.Date(2018, 6, 15, 12, 0, 0, 0, time.Local)
+	var day = func(d int) time.Time {
+		return time.Date(2018, 6, d, 0, 0, 0, 0, time.Local)
+	}
+
+	var cases = []struct {
+		args []string
+		want *LedgerQuery
+		fail bool
+	}{
+		{nil, &LedgerQuery{Since: now.AddDate(0, 0, -1), Limit: ledgerDefaultLimit}, false},
+		{[]string{"2018-06-01~2018-06-02", "receipt", "failed", "20"}, &LedgerQuery{Kind: LedgerReceipt, Status: "failed", Since: day(1), Until: day(3), Limit: 20}, false},
+		{[]string{"2018-06-01", "", "", "", "42"}, &LedgerQuery{ID: "42", Since: day(1), Until: day(2), Limit: ledgerDefaultLimit}, false},
+		{[]string{"", "", "", "999999"}, &LedgerQuery{Since: now.AddDate(0, 0, -1), Limit: ledgerMaxLimit}, false},
+		{[]string{"yesterday"}, nil, true},
+		{[]string{"", "upload"}, nil, true},
+		{[]string{"", "", "", "-1"}, nil, true},
+		{[]string{"", "", "", "x"}, nil, true},
+	}
+
+	for _, c := range cases {
+		var q, err = parseLedgerQuery(c.args, now)
+		if c.fail {
+			if nil == err {
+				t.Errorf("%q：应返回错误", c.args)
+			}
+			continue
+		}
+
+		if nil != err {
+			t.Errorf("%q：%v", c.args, err)
+		} else if q.Kind != c.want.Kind || q.Status != c.want.Status || q.ID != c.want.ID || q.Limit != c.want.Limit || !q.Since.Equal(c.want.Since) || !q.Until.Equal(c.want.Until) {
+			t.Errorf("%q：查询条件为 %+v，应为 %+v", c.args, q, c.want)
+		}
+	}
+}
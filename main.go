@@ -1,7 +1,22 @@
 package main
 
+import (
+	"flag"
+	"os"
+)
+
 func main() {
 	var app = new(App)
 
-	app.Run()
+	if len(os.Args) > 1 && "run" == os.Args[1] {
+		var fs = flag.NewFlagSet("run", flag.ExitOnError)
+		var config = fs.String("config", "", "配置文件路径，默认为程序目录下的 config.json")
+		var debug = fs.Int("debug", -1, "日志级别：0 关闭 1 严重错误 2 常规错误 3 提示信息 4 调试信息，默认使用配置文件中的值")
+
+		fs.Parse(os.Args[2:])
+
+		os.Exit(app.Daemon(*config, *debug))
+	}
+
+	os.Exit(app.Run())
 }
@@ -173,6 +173,11 @@ func GetAppPath() string {
 	return strings.Replace(filepath.Dir(AbsPath(curPath)), "\\", "/", -1)
 }
 
+// LogFile 返回日志文件路径
+func LogFile() string {
+	return GetAppPath() + "/swa.log"
+}
+
 // FileGetContents Get bytes to file.
 // if non-exist, create this file.
 func FileGetContents(filename string) (data []byte, e error) {
@@ -217,3 +222,34 @@ func FilePutContents(filename string, content []byte, append bool) error {
 	_, err = fp.Write(content)
 	return err
 }
+
+// FileAtomicPutContents 先写入同目录下的临时文件并同步到磁盘，再重命名为目标文件，
+// 避免其它程序读取到只写了一半的文件，目录不存在时自动创建
+func FileAtomicPutContents(filename string, content []byte) error {
+	var dir = filepath.Dir(filename)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// 临时文件以点开头且没有扩展名，单一窗口客户端不会当作报文读取
+	fp, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+
+	var tmp = fp.Name()
+	if _, err = fp.Write(content); err == nil {
+		err = fp.Sync()
+	}
+	if e := fp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+
+	return err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EventKind 事件类型
+type EventKind string
+
+// 事件类型定义
+const (
+	EventMessage       EventKind = "message"        // 一般提示消息
+	EventDebug         EventKind = "debug"          // 调试信息
+	EventCounter       EventKind = "counter"        // 计数器更新
+	EventDownload      EventKind = "download"       // 报文下载成功
+	EventReceipt       EventKind = "receipt"        // 回执上传成功
+	EventReceiptFailed EventKind = "receipt_failed" // 回执上传失败
+	EventCommandFailed EventKind = "command_failed" // 远程命令执行失败
+	EventAuthExpired   EventKind = "auth_expired"   // 账号授权过期
+	EventWatcherError  EventKind = "watcher_error"  // 回执目录监听出错
+	EventClockSkew     EventKind = "clock_skew"     // 本机与服务器时间偏差过大
+)
+
+// 事件级别，与配置选项中的调试级别一一对应
+const (
+	LevelFatal = 1 // 严重错误
+	LevelError = 2 // 常规错误
+	LevelInfo  = 3 // 提示信息
+	LevelDebug = 4 // 调试信息
+)
+
+// Event 结构化事件
+type Event struct {
+	Kind    EventKind         `json:"kind" label:"事件类型"`
+	Level   int               `json:"level" label:"事件级别"`
+	Time    time.Time         `json:"time" label:"事件时间"`
+	Title   string            `json:"title,omitempty" label:"消息标题"`
+	Message string            `json:"message" label:"消息内容"`
+	Data    map[string]string `json:"data,omitempty" label:"附加数据"`
+	Counter *Counter          `json:"counter,omitempty" label:"计数器"`
+}
+
+// NewEvent 创建事件
+func NewEvent(kind EventKind, level int, msg string, data map[string]string) *Event {
+	return &Event{
+		Kind:    kind,
+		Level:   level,
+		Time:    time.Now(),
+		Message: msg,
+		Data:    data,
+	}
+}
+
+// Notifier 事件通知接收者
+type Notifier interface {
+	Notify(e *Event)
+}
+
+// Notifiers 把事件同时分发给多个通知接收者
+type Notifiers []Notifier
+
+// Notify 分发事件
+func (ns Notifiers) Notify(e *Event) {
+	for _, n := range ns {
+		if nil != n {
+			n.Notify(e)
+		}
+	}
+}
+
+// Recorder 记录收到的全部事件，供测试检查
+type Recorder struct {
+	mux    sync.Mutex `label:"事件列表锁"`
+	events []*Event   `label:"事件列表"`
+}
+
+// Notify 记录事件
+func (r *Recorder) Notify(e *Event) {
+	r.mux.Lock()
+	r.events = append(r.events, e)
+	r.mux.Unlock()
+}
+
+// Events 返回已记录的事件
+func (r *Recorder) Events() []*Event {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return append([]*Event(nil), r.events...)
+}
+
+// Reset 清空已记录的事件
+func (r *Recorder) Reset() {
+	r.mux.Lock()
+	r.events = nil
+	r.mux.Unlock()
+}
+
+// NewLogNotifier 创建写入指定输出的日志通知
+func NewLogNotifier(opt *Options, w io.Writer) *LogNotifier {
+	return &LogNotifier{opt: opt, w: w}
+}
+
+// OpenLogNotifier 创建追加写入日志文件的日志通知
+func OpenLogNotifier(opt *Options, file string) (*LogNotifier, error) {
+	var flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	var fp, err = os.OpenFile(file, flag, os.ModePerm)
+	if nil != err {
+		return nil, err
+	}
+
+	return &LogNotifier{opt: opt, w: fp, fp: fp}, nil
+}
+
+// LogNotifier 按调试级别把事件写成文本日志
+type LogNotifier struct {
+	opt *Options   `label:"配置选项"`
+	w   io.Writer  `label:"日志输出"`
+	fp  *os.File   `label:"日志文件"`
+	mux sync.Mutex `label:"日志写入锁"`
+}
+
+// Notify 写入一行日志
+func (l *LogNotifier) Notify(e *Event) {
+	if l.opt.Debug > 0 && e.Level <= l.opt.Debug {
+		var line = FormatEvent(e)
+
+		l.mux.Lock()
+		l.w.Write(line)
+		l.mux.Unlock()
+	}
+}
+
+// Close 关闭日志文件
+func (l *LogNotifier) Close() error {
+	if nil != l.fp {
+		return l.fp.Close()
+	}
+
+	return nil
+}
+
+// FormatEvent 格式化一行事件日志
+func FormatEvent(e *Event) []byte {
+	var buf = new(bytes.Buffer)
+	buf.WriteString(e.Time.Format("2006-01-02 15:04:05"))
+	buf.WriteString(" [")
+	buf.WriteString(string(e.Kind))
+	buf.WriteString("] ")
+
+	if "" != e.Title {
+		buf.WriteString(e.Title)
+		buf.WriteString(" ")
+	}
+
+	if nil != e.Counter {
+		buf.WriteString("暂存：" + strconv.FormatUint(e.Counter.Download, 10))
+		buf.WriteString(" 审核：" + strconv.FormatUint(e.Counter.Upload, 10))
+		buf.WriteString(" 出错：" + strconv.FormatUint(e.Counter.Error, 10))
+	} else {
+		buf.WriteString(e.Message)
+	}
+
+	buf.WriteString("\r\n")
+
+	return buf.Bytes()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestExecuteNotifyFanOut 事件发送到组合通知中的每一个通知
+func TestExecuteNotifyFanOut(t *testing.T) {
+	var a, b = new(Recorder), new(Recorder)
+	var exe = &Execute{notifier: Notifiers{a, b}}
+
+	exe.notify(EventMessage, LevelError, "出错", map[string]string{"file": "a.xml"})
+	exe.notify(EventDebug, LevelDebug, "调试", nil)
+
+	for _, r := range []*Recorder{a, b} {
+		var events = r.Events()
+		if 2 != len(events) {
+			t.Fatalf("收到 %d 个事件，应为 2 个", len(events))
+		}
+		if EventMessage != events[0].Kind || LevelError != events[0].Level || "出错" != events[0].Message || "a.xml" != events[0].Data["file"] {
+			t.Errorf("第一个事件不正确：%+v", events[0])
+		}
+		if EventDebug != events[1].Kind {
+			t.Errorf("第二个事件不正确：%+v", events[1])
+		}
+	}
+
+	a.Reset()
+	if 0 != len(a.Events()) {
+		t.Errorf("Reset 后仍有事件")
+	}
+}
+
+// TestWebhookNotifierFilter Webhook 只推送不超过 Webhook 级别的普通事件，关闭日志时也推送
+func TestWebhookNotifierFilter(t *testing.T) {
+	var received = make(chan *Event, 10)
+	var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e = new(Event)
+		if err := json.NewDecoder(r.Body).Decode(e); nil == err {
+			received <- e
+		}
+	}))
+	defer srv.Close()
+
+	var w = NewWebhookNotifier(&Options{Debug: 0, WebhookLevel: LevelError}, srv.URL)
+	var counter = NewEvent(EventCounter, LevelInfo, "", nil)
+	counter.Counter = new(Counter)
+
+	var cases = []struct {
+		event *Event
+		push  bool
+	}{
+		{counter, false},
+		{NewEvent(EventDebug, LevelFatal, "调试", nil), false},
+		{NewEvent(EventMessage, LevelDebug, "调试级别", nil), false},
+		{NewEvent(EventMessage, LevelInfo, "高于配置级别", nil), false},
+		{NewEvent(EventReceiptFailed, LevelError, "上传失败", nil), true},
+		{NewEvent(EventMessage, LevelFatal, "严重错误", nil), true},
+	}
+
+	var want []string
+	for _, c := range cases {
+		w.Notify(c.event)
+		if c.push {
+			want = append(want, c.event.Message)
+		}
+	}
+
+	// 事件按顺序推送，收到最后一个应推送的事件时被过滤的事件也不会再到达
+	for _, msg := range want {
+		select {
+		case e := <-received:
+			if msg != e.Message {
+				t.Errorf("推送的事件为 %q，应为 %q", e.Message, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("没有收到事件 %q", msg)
+		}
+	}
+
+	select {
+	case e := <-received:
+		t.Errorf("推送了应过滤的事件 %q", e.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
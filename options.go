@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"os"
 	"path/filepath"
 	"strings"
 )
@@ -10,44 +11,97 @@ import (
 // ErrNeedValidateAuth 需要检查账号授权
 var ErrNeedValidateAuth = errors.New("need validate auth")
 
+// ErrAuthExpired 服务器登录会话已过期
+var ErrAuthExpired = errors.New("登录会话已过期")
+
 // ErrFSWatcherStop 单一窗口回执目录事件监听停止
 var ErrFSWatcherStop = errors.New("file system watcher stopped")
 
+// CommandError 不需要重试的远程命令错误，直接向服务器上报执行失败
+type CommandError struct {
+	Reason string `label:"失败原因"`
+}
+
+// Error 返回失败原因
+func (e *CommandError) Error() string {
+	return e.Reason
+}
+
+// ReceiptError 回执内容本身有误导致的上传失败，重试也不会成功，不再留在上传队列中
+type ReceiptError struct {
+	Reason string `label:"失败原因"`
+}
+
+// Error 返回失败原因
+func (e *ReceiptError) Error() string {
+	return e.Reason
+}
+
 // DebugLevel 调试级别
 type DebugLevel struct {
 	Value int
 	Label string
 }
 
+// AuthMode 账号授权方式
+type AuthMode struct {
+	Value string
+	Label string
+}
+
 // Counter 计数器
 type Counter struct {
-	Error    uint64
-	Upload   uint64
-	Download uint64
+	Error    uint64 `json:"error"`
+	Upload   uint64 `json:"upload"`
+	Download uint64 `json:"download"`
 }
 
 // Options 配置选项
 type Options struct {
-	Status     bool     `json:"-" label:"连接状态"`
-	Debug      int      `json:"debug" label:"调试级别"`
-	Timeout    int      `json:"timeout" label:"通信超时时间"`
-	Interval   int      `json:"interval" label:"轮询远程服务器数据时间间隔"`
-	TimeLag    int      `json:"time_lag" label:"本身与远程服务器时间差间隔"`
-	ECid       string   `json:"ecid" label:"企业身份ID"`
-	UID        string   `json:"uid" label:"用户ID"`
-	UName      string   `json:"uname" label:"用户名"`
-	Pwd        string   `json:"-" label:"账号密码"`
-	Token      string   `json:"token" label:"数据签名 Token"`
-	URL        string   `json:"url" label:"数通天下快捷报关服务器 URL"`
-	DataPath   string   `json:"data_path" label:"单一窗口数据目录"`
-	Counter    *Counter `json:"-" label:"计数器"`
-	configFile string   `label:"配置文件路径"`
-	oldUName   string   `label:"旧用户名"`
-}
-
-// Init 初始化配置选项
-func (opt *Options) Init() {
-	opt.configFile = GetAppPath() + "/config.json"
+	Status          bool              `json:"-" label:"连接状态"`
+	Debug           int               `json:"debug" label:"调试级别"`
+	Timeout         int               `json:"timeout" label:"通信超时时间"`
+	Interval        int               `json:"interval" label:"轮询远程服务器数据时间间隔"`
+	MinInterval     int               `json:"min_interval" label:"有新命令时轮询远程服务器的最短间隔"`
+	TimeLag         int               `json:"time_lag" label:"本身与远程服务器时间差间隔"`
+	Workers         int               `json:"workers" label:"并发执行远程命令的工作协程数量"`
+	ScanDays        int               `json:"scan_days" label:"首次运行时补传最近几天的回执，小于 0 时补传全部"`
+	Retry           RetryPolicy       `json:"retry" label:"远程命令失败重试策略"`
+	ECid            string            `json:"ecid" label:"企业身份ID"`
+	UID             string            `json:"uid" label:"用户ID"`
+	UName           string            `json:"uname" label:"用户名"`
+	Pwd             string            `json:"-" label:"账号密码"`
+	AuthMode        string            `json:"auth_mode" label:"账号授权方式"`
+	DeviceKey       string            `json:"device_key,omitempty" label:"设备密钥"`
+	Token           string            `json:"token,omitempty" label:"数据签名 Token"`
+	URL             string            `json:"url" label:"数通天下快捷报关服务器 URL"`
+	DataPath        string            `json:"data_path" label:"单一窗口数据目录"`
+	Webhook         string            `json:"webhook" label:"事件推送 Webhook URL"`
+	WebhookLevel    int               `json:"webhook_level" label:"Webhook 推送的事件级别，1 严重错误、2 常规错误、3 提示信息，与日志级别无关"`
+	UploadEncoding  string            `json:"upload_encoding" label:"回执上传编码，json、raw 或 both"`
+	UploadMultipart bool              `json:"upload_multipart" label:"原始回执是否以 multipart 文件上传，否则以 base64 上传"`
+	OutputEncoding  string            `json:"output_encoding" label:"下载报文的字符编码，auto 按报文 XML 声明，或者 UTF-8、GBK、GB18030"`
+	ValidateRules   []*ValidationRule `json:"validate_rules" label:"下载报文的结构规则，根元素相同时覆盖内置规则"`
+	Push            bool              `json:"push" label:"是否连接服务器命令推送通道"`
+	AllowPaths      []string          `json:"allow_paths" label:"允许写入的单一窗口子目录"`
+	Counter         *Counter          `json:"-" label:"计数器"`
+	configFile      string            `label:"配置文件路径"`
+	storeFile       string            `label:"本地存储文件路径"`
+	creds           CredentialStore   `label:"凭据存储"`
+	oldUName        string            `label:"旧用户名"`
+}
+
+// Init 初始化配置选项，configFile 为空时使用程序目录下的 config.json
+func (opt *Options) Init(configFile string) {
+	if "" == configFile {
+		configFile = GetAppPath() + "/config.json"
+	}
+
+	opt.configFile = configFile
+	opt.storeFile = GetAppPath() + "/swa.db"
+
+	// 凭据加密密钥可以通过环境变量 SWA_SECRET 指定，没有指定时与本机绑定
+	opt.creds = NewFileCredentialStore(GetAppPath()+"/credential.dat", os.Getenv("SWA_SECRET"))
 
 	opt.Load()
 	if 0 == opt.Timeout {
@@ -58,14 +112,48 @@ func (opt *Options) Init() {
 		opt.Interval = 300
 	}
 
+	if 0 == opt.MinInterval {
+		opt.MinInterval = 5
+	}
+
 	if 0 == opt.TimeLag {
 		opt.TimeLag = 300
 	}
 
+	if 0 == opt.Workers {
+		opt.Workers = 4
+	}
+
+	if opt.WebhookLevel <= 0 || opt.WebhookLevel > LevelInfo {
+		opt.WebhookLevel = LevelInfo
+	}
+
+	if 0 == opt.ScanDays {
+		opt.ScanDays = 7
+	}
+
+	opt.Retry.Init()
+
+	if UploadRaw != opt.UploadEncoding && UploadBoth != opt.UploadEncoding {
+		opt.UploadEncoding = UploadJSON
+	}
+
+	if "" == NormalizeCharset(opt.OutputEncoding) {
+		opt.OutputEncoding = CharsetAuto
+	}
+
+	if "" == opt.AuthMode {
+		opt.AuthMode = AuthModeForm
+	}
+
 	if "" == opt.DataPath {
 		opt.DataPath = "C:\\ImpPath"
 	}
 
+	if 0 == len(opt.AllowPaths) {
+		opt.AllowPaths = []string{"*/OutBox"}
+	}
+
 	// 先保存一个用户名副本，如果修改了用户名就验证密码
 	opt.oldUName = opt.UName
 
@@ -83,30 +171,120 @@ func (opt *Options) Validate() error {
 		err = errors.New("单一窗口数据目录不存在或不可读写")
 	} else if "" == opt.UName {
 		err = errors.New("用户名不能为空")
-	} else if "" == opt.Pwd && opt.oldUName != opt.UName {
-		err = errors.New("登录密码不能为空")
+	} else if "" == opt.Pwd && "" == opt.DeviceKey && opt.oldUName != opt.UName {
+		err = errors.New("登录密码与设备密钥至少需要填写一项")
+	} else if opt.MinInterval > opt.Interval {
+		err = errors.New("最短轮询间隔不能大于轮询间隔")
 	}
 
-	if nil == err && "" != opt.Pwd {
+	if nil == err && ("" != opt.Pwd || "" != opt.DeviceKey) {
 		err = ErrNeedValidateAuth
 	}
 
 	return err
 }
 
-// Load 从文件加载配置
+// Password 返回登录密码，没有输入时从凭据存储读取
+func (opt *Options) Password() string {
+	if "" == opt.Pwd {
+		return opt.GetCredential("password")
+	}
+
+	return opt.Pwd
+}
+
+// GetCredential 从凭据存储读取数据，读取失败时返回空字符串
+func (opt *Options) GetCredential(key string) string {
+	if nil != opt.creds {
+		if v, err := opt.creds.Get(key); nil == err {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// CredentialError 返回凭据文件无法解密的错误，没有出错时返回 nil
+func (opt *Options) CredentialError() error {
+	if s, ok := opt.creds.(*FileCredentialStore); ok {
+		return s.Err()
+	}
+
+	return nil
+}
+
+// SetCredential 保存数据到凭据存储，值为空时删除
+func (opt *Options) SetCredential(key string, value string) error {
+	if nil == opt.creds {
+		return errors.New("凭据存储不可用")
+	} else if "" == value {
+		return opt.creds.Delete(key)
+	}
+
+	return opt.creds.Set(key, value)
+}
+
+// secrets 返回保存在凭据存储中、不写入配置文件的选项
+func (opt *Options) secrets() map[string]*string {
+	return map[string]*string{
+		"token":      &opt.Token,
+		"device_key": &opt.DeviceKey,
+	}
+}
+
+// Load 从文件加载配置，登录密码、设备密钥与签名 Token 从凭据存储读取，配置文件中遗留的明文数据迁移到凭据存储
 func (opt *Options) Load() error {
+	var migrate bool
+	var secrets = opt.secrets()
+	for _, v := range secrets {
+		*v = ""
+	}
+	opt.Pwd = ""
+
 	var data, err = FileGetContents(opt.configFile)
 	if nil == err && nil != data {
 		err = json.Unmarshal(data, opt)
 	}
 
+	if nil != opt.creds {
+		for k, v := range secrets {
+			if "" != *v {
+				migrate = true
+			} else {
+				*v = opt.GetCredential(k)
+			}
+		}
+
+		opt.Pwd = opt.GetCredential("password")
+		if migrate {
+			opt.Save()
+		}
+	}
+
 	return err
 }
 
-// Save 保存配置到文件
+// Save 保存配置到文件，登录密码、设备密钥与签名 Token 加密保存到凭据存储，不写入配置文件
 func (opt *Options) Save() error {
-	var data, err = json.Marshal(opt)
+	var v = *opt
+	if nil != opt.creds {
+		if "" != opt.Pwd {
+			if err := opt.SetCredential("password", opt.Pwd); nil != err {
+				return err
+			}
+		}
+
+		for k, s := range opt.secrets() {
+			if err := opt.SetCredential(k, *s); nil != err {
+				return err
+			}
+		}
+
+		v.Token = ""
+		v.DeviceKey = ""
+	}
+
+	var data, err = json.Marshal(&v)
 	if nil == err && nil != data {
 		err = FilePutContents(opt.configFile, data, false)
 	}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 推送通道重连与空闲检测的时间，连接保持超过 pushStableTime 后断开才从最短间隔重新开始退避
+var (
+	pushRetryMin    = time.Second
+	pushRetryMax    = 5 * time.Minute
+	pushIdleTimeout = 90 * time.Second
+	pushStableTime  = time.Minute
+)
+
+// ErrPushUnsupported 服务器没有提供命令推送通道
+var ErrPushUnsupported = errors.New("服务器不支持命令推送")
+
+// subscribe 保持与服务器命令推送通道的连接，断开后按指数退避重连，推送不可用期间由轮询读取命令
+func (exe *Execute) subscribe(ctx context.Context, wake chan<- struct{}) {
+	var delay = pushRetryMin
+
+	for {
+		// 连接建立后很快断开的情况仍然继续退避，以免服务器异常时频繁重连
+		var up, err = exe.listen(ctx, wake)
+		if up >= pushStableTime {
+			delay = pushRetryMin
+		}
+		if nil != err {
+			exe.notify(EventMessage, LevelDebug, "服务器命令推送通道断开："+err.Error(), nil)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+
+		if delay *= 2; delay > pushRetryMax {
+			delay = pushRetryMax
+		}
+	}
+}
+
+// listen 连接 api/Chinaport/Events 推送通道读取 Server-Sent Events，收到命令事件时唤醒命令消费循环，
+// 返回连接保持的时间，没有建立连接时为 0
+func (exe *Execute) listen(ctx context.Context, wake chan<- struct{}) (time.Duration, error) {
+	var start = time.Now()
+	var resp, err = exe.stream(ctx)
+	if ErrAuthExpired == err {
+		if err = exe.reauth(ctx, start); nil == err {
+			resp, err = exe.stream(ctx)
+		}
+	}
+	if nil != err {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	var connected = time.Now()
+
+	// 服务器应定时发送注释行保持连接，长时间没有数据时认为连接已失效
+	var idle = time.AfterFunc(pushIdleTimeout, func() {
+		resp.Body.Close()
+	})
+	defer idle.Stop()
+
+	// 连接建立前可能错过了推送，先读取一次命令
+	wakeup(wake)
+
+	// 空行结束一个事件，只有注释行没有 data 字段的不是事件
+	var data bool
+	var event string
+	var scanner = bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		idle.Reset(pushIdleTimeout)
+
+		var line = scanner.Text()
+		if "" == line {
+			if data && ("" == event || "message" == event || "command" == event) {
+				wakeup(wake)
+			}
+
+			data, event = false, ""
+		} else if strings.HasPrefix(line, "event:") {
+			event = strings.TrimSpace(line[6:])
+		} else if strings.HasPrefix(line, "data") {
+			data = true
+		}
+	}
+
+	if err = scanner.Err(); nil == err {
+		err = errors.New("连接已被服务器关闭")
+	}
+
+	return time.Since(connected), err
+}
+
+// stream 发起推送通道请求，响应头通过检查后返回未读取的响应
+func (exe *Execute) stream(ctx context.Context) (*http.Response, error) {
+	var param = map[string]string{"ecid": exe.options.ECid, "admin_id": exe.options.UID}
+	if "" != exe.options.Token {
+		param = SignParam(exe.options.Token, exe.client.Clock().Now(), param)
+	}
+
+	var url = exe.options.URL + "api/Chinaport/Events"
+	var deadline = exe.client.Deadline()
+	deadline.Body = 0
+
+	var payload = &ClientPayload{
+		KeepAlive: true,
+		Method:    "GET",
+		Data:      exe.mapToQS(param),
+		Deadline:  &deadline,
+		Stream:    true,
+		Header: &http.Header{
+			"Accept":        []string{"text/event-stream"},
+			"Cache-Control": []string{"no-cache"},
+		},
+	}
+
+	var resp, err = exe.client.Read(ctx, url, payload)
+	if nil != err {
+		return nil, err
+	}
+
+	if IsAuthExpired(url, resp) {
+		err = ErrAuthExpired
+	} else if http.StatusOK != resp.StatusCode || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		err = ErrPushUnsupported
+	}
+	if nil != err {
+		resp.Body.Close()
+
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// wakeup 通知命令消费循环立即读取命令，已有待处理的通知时忽略
+func wakeup(wake chan<- struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
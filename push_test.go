@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestExecute 创建连接到测试服务器的指令执行器，通知记录在 Recorder 中
+func newTestExecute(url string) *Execute {
+	var r = new(Recorder)
+
+	return &Execute{
+		options:  &Options{URL: url + "/", Timeout: 5},
+		notifier: r,
+		mux:      new(sync.Mutex),
+		client:   NewClient(0, 5*time.Second, r),
+	}
+}
+
+// waitWake 等待唤醒通知，超时返回 false
+func waitWake(wake <-chan struct{}, timeout time.Duration) bool {
+	select {
+	case <-wake:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// TestListenWakeup 连接建立与收到命令事件时唤醒命令消费循环，其它事件不唤醒
+func TestListenWakeup(t *testing.T) {
+	var send = make(chan string)
+	var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+
+		for {
+			select {
+			case v := <-send:
+				fmt.Fprint(w, v)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	var exe = newTestExecute(srv.URL)
+	var wake = make(chan struct{}, 1)
+	var done = make(chan error, 1)
+	go func() {
+		var _, err = exe.listen(context.Background(), wake)
+		done <- err
+	}()
+
+	if !waitWake(wake, 5*time.Second) {
+		t.Fatal("连接建立后没有唤醒")
+	}
+
+	send <- ": ping\n\nevent: status\ndata: 1\n\n"
+	if waitWake(wake, 200*time.Millisecond) {
+		t.Error("注释行与其它事件不应唤醒")
+	}
+
+	send <- "event: command\ndata: 1\n\n"
+	if !waitWake(wake, 5*time.Second) {
+		t.Error("收到命令事件后没有唤醒")
+	}
+
+	send <- "data: 1\n\n"
+	if !waitWake(wake, 5*time.Second) {
+		t.Error("收到没有事件名的事件后没有唤醒")
+	}
+
+	srv.CloseClientConnections()
+	select {
+	case err := <-done:
+		if nil == err {
+			t.Error("连接断开后应返回错误")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("连接断开后没有返回")
+	}
+}
+
+// TestListenIdleTimeout 长时间没有收到数据时断开连接
+func TestListenIdleTimeout(t *testing.T) {
+	var idle = pushIdleTimeout
+	pushIdleTimeout = 200 * time.Millisecond
+	defer func() {
+		pushIdleTimeout = idle
+	}()
+
+	var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	var exe = newTestExecute(srv.URL)
+	var done = make(chan time.Duration, 1)
+	go func() {
+		var up, _ = exe.listen(context.Background(), make(chan struct{}, 1))
+		done <- up
+	}()
+
+	select {
+	case up := <-done:
+		if up < pushIdleTimeout {
+			t.Errorf("连接保持 %v 后断开，应在空闲 %v 后断开", up, pushIdleTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("空闲超时后没有断开连接")
+	}
+}
+
+// TestSubscribeReconnect 连接断开后重连，连接很快断开时继续退避，不支持推送时不会建立连接
+func TestSubscribeReconnect(t *testing.T) {
+	var min, max, stable = pushRetryMin, pushRetryMax, pushStableTime
+	pushRetryMin, pushRetryMax, pushStableTime = 20*time.Millisecond, time.Second, time.Hour
+	defer func() {
+		pushRetryMin, pushRetryMax, pushStableTime = min, max, stable
+	}()
+
+	var count int32
+	var srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "/none/api/Chinaport/Events" == r.URL.Path {
+			http.NotFound(w, r)
+			return
+		}
+
+		atomic.AddInt32(&count, 1)
+		w.Header().Set("Content-Type", "text/event-stream")
+	}))
+	defer srv.Close()
+
+	var exe = newTestExecute(srv.URL)
+	var ctx, cancel = context.WithCancel(context.Background())
+	var done = make(chan struct{})
+	go func() {
+		exe.subscribe(ctx, make(chan struct{}, 1))
+		close(done)
+	}()
+
+	// 按 20、40、80、160、320 毫秒退避，每次连接后都重置时会重连约 30 次
+	time.Sleep(600 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("停止后没有退出")
+	}
+
+	if n := atomic.LoadInt32(&count); n < 3 || n > 8 {
+		t.Errorf("600 毫秒内连接 %d 次，应为 3 到 8 次", n)
+	}
+
+	if _, err := newTestExecute(srv.URL + "/none").stream(context.Background()); nil == err {
+		t.Error("响应不是 text/event-stream 时应返回错误")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// 回执上传重试的最短与最长间隔
+const (
+	queueRetryMin = time.Second * 10
+	queueRetryMax = time.Hour
+)
+
+// QueueItem 回执上传队列项
+type QueueItem struct {
+	File     string    `json:"file" label:"回执文件路径"`
+	Content  []byte    `json:"content" label:"回执文件内容"`
+	Attempts int       `json:"attempts" label:"已上传次数"`
+	Error    string    `json:"error" label:"最后一次上传错误"`
+	Next     time.Time `json:"next" label:"下次上传时间"`
+	Created  time.Time `json:"created" label:"入队时间"`
+}
+
+// NewQueue 创建回执上传队列
+func NewQueue(store *Store) *Queue {
+	return &Queue{store: store, bucket: "queue", busy: make(map[string]time.Time)}
+}
+
+// Queue 持久化的回执上传队列，服务器确认接收前回执一直保留在队列中并按指数退避重试；
+// 正在上传的队列项不会被重试取出，同一文件重新入队后，之前入队的内容上传完成时不会影响新的队列项
+type Queue struct {
+	store  *Store               `label:"本地存储"`
+	bucket string               `label:"存储桶名称"`
+	mux    sync.Mutex           `label:"队列锁"`
+	busy   map[string]time.Time `label:"正在上传的队列项，值为入队时间"`
+}
+
+// Push 回执加入队列并标记为正在上传，同一文件再次入队时覆盖原内容并重新计数
+func (q *Queue) Push(file string, content []byte) (*QueueItem, error) {
+	var now = time.Now()
+	var item = &QueueItem{
+		File:    file,
+		Content: content,
+		Next:    now.Add(queueRetryMin),
+		Created: now,
+	}
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	var err = q.store.Put(q.bucket, file, item)
+	if nil == err {
+		q.busy[file] = item.Created
+	}
+
+	return item, err
+}
+
+// Done 服务器已确认接收或回执内容有误不再重试，从队列移除，文件已重新入队时保留新的队列项
+func (q *Queue) Done(item *QueueItem) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	defer q.release(item)
+	if !q.current(item) {
+		return nil
+	}
+
+	return q.store.Delete(q.bucket, item.File)
+}
+
+// Fail 记录上传失败并计算下次重试时间，文件已重新入队时不再记录
+func (q *Queue) Fail(item *QueueItem, err error) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	defer q.release(item)
+	if !q.current(item) {
+		return nil
+	}
+
+	var delay = queueRetryMin
+	for i := 0; i < item.Attempts && delay < queueRetryMax; i++ {
+		delay = delay * 2
+	}
+	if delay > queueRetryMax {
+		delay = queueRetryMax
+	}
+
+	item.Attempts++
+	item.Error = err.Error()
+	item.Next = time.Now().Add(delay)
+
+	return q.store.Put(q.bucket, item.File, item)
+}
+
+// Release 放弃上传，队列项保持原样等待重试
+func (q *Queue) Release(item *QueueItem) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	q.release(item)
+}
+
+// Due 返回已到重试时间且没有正在上传的队列项，并标记为正在上传，上传后需要调用 Done、Fail 或 Release
+func (q *Queue) Due(now time.Time) []*QueueItem {
+	var items []*QueueItem
+
+	q.mux.Lock()
+	defer q.mux.Unlock()
+
+	q.store.Each(q.bucket, func(key string, data []byte) error {
+		var item = new(QueueItem)
+		if err := json.Unmarshal(data, item); nil == err && !item.Next.After(now) {
+			if _, ok := q.busy[item.File]; !ok {
+				q.busy[item.File] = item.Created
+				items = append(items, item)
+			}
+		}
+
+		return nil
+	})
+
+	return items
+}
+
+// current 检查队列中保存的仍是 item 这次入队的内容
+func (q *Queue) current(item *QueueItem) bool {
+	var stored = new(QueueItem)
+	if ok, err := q.store.Get(q.bucket, item.File, stored); nil != err || !ok {
+		return false
+	}
+
+	return stored.Created.Equal(item.Created)
+}
+
+// release 取消 item 的正在上传标记，文件已重新入队时保留新的标记
+func (q *Queue) release(item *QueueItem) {
+	if created, ok := q.busy[item.File]; ok && created.Equal(item.Created) {
+		delete(q.busy, item.File)
+	}
+}
+
+// Len 返回队列长度
+func (q *Queue) Len() int {
+	return q.store.Count(q.bucket)
+}
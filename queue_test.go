@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestQueueInFlight 正在上传的队列项不会被重试取出，重新入队后旧内容的上传结果不影响新的队列项
+func TestQueueInFlight(t *testing.T) {
+	var store, clean = openTestStore(t)
+	defer clean()
+
+	var q = NewQueue(store)
+	var later = time.Now().Add(time.Hour)
+
+	var a, err = q.Push("a.xml", []byte("1"))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if items := q.Due(later); 0 != len(items) {
+		t.Fatalf("正在上传的队列项被重试取出")
+	}
+
+	q.Fail(a, errors.New("出错"))
+	var items = q.Due(later)
+	if 1 != len(items) || 1 != items[0].Attempts {
+		t.Fatalf("上传失败后应可以重试：%+v", items)
+	}
+	if 0 != len(q.Due(later)) {
+		t.Fatalf("重试中的队列项被再次取出")
+	}
+
+	// 重试途中文件被修改重新入队，旧内容上传完成后保留新的队列项
+	var b, _ = q.Push("a.xml", []byte("2"))
+	q.Done(items[0])
+	if 1 != q.Len() {
+		t.Fatalf("旧内容上传完成后删除了新的队列项")
+	}
+	q.Fail(items[0], errors.New("出错"))
+	if 0 != len(q.Due(later)) {
+		t.Fatalf("旧内容上传失败后新的队列项被取出")
+	}
+
+	q.Release(b)
+	if items = q.Due(later); 1 != len(items) || "2" != string(items[0].Content) || 0 != items[0].Attempts {
+		t.Fatalf("放弃上传后应保留新的队列项：%+v", items)
+	}
+
+	q.Done(items[0])
+	if 0 != q.Len() {
+		t.Errorf("上传完成后没有移出队列")
+	}
+}
+
+// TestSettleReceiptError 回执内容有误时移出队列，其它错误等待重试
+func TestSettleReceiptError(t *testing.T) {
+	var store, clean = openTestStore(t)
+	defer clean()
+
+	var exe = &Execute{queue: NewQueue(store)}
+	var a, _ = exe.queue.Push("a.xml", []byte("<a"))
+	var b, _ = exe.queue.Push("b.xml", []byte("<b/>"))
+
+	exe.settle(context.Background(), a, &ReceiptError{Reason: "回执内容不是有效的 XML"})
+	exe.settle(context.Background(), b, errors.New("网络错误"))
+
+	var items = exe.queue.Due(time.Now().Add(time.Hour))
+	if 1 != len(items) || "b.xml" != items[0].File {
+		t.Errorf("队列中应只保留等待重试的 b.xml：%+v", items)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"time"
+)
+
+// 回执上传动作
+const (
+	ReceiptActionReceipt = "receipt"
+	ReceiptActionStatus  = "status"
+	ReceiptActionOther   = "other"
+)
+
+// 回执所属业务类型
+const (
+	BusinessDeclaration = "declaration"
+	BusinessManifest    = "manifest"
+	BusinessCEB         = "ceb"
+	BusinessOther       = "other"
+)
+
+// businessDirs 单一窗口数据目录下业务子目录名前缀对应的业务类型
+var businessDirs = []struct {
+	Prefix   string
+	Business string
+}{
+	{"deccus", BusinessDeclaration},
+	{"dec", BusinessDeclaration},
+	{"rmft", BusinessManifest},
+	{"mft", BusinessManifest},
+	{"manifest", BusinessManifest},
+	{"ceb", BusinessCEB},
+}
+
+// ReceiptName 单一窗口回执文件名解析结果
+type ReceiptName struct {
+	File        string `label:"回执文件名"`
+	Business    string `label:"业务类型"`
+	Action      string `label:"上传动作，receipt 业务回执，status 导入结果，other 无法识别"`
+	Status      string `label:"导入结果，successed 或 failed"`
+	ID          string `label:"导入结果对应的报文 ID"`
+	OriginalBN  string `label:"业务回执对应的原始报文编号，与旧版本一致取文件名第二段，没有后续段时包含扩展名"`
+	MessageType string `label:"跨境电商回执的报文类型，如 CEB312"`
+}
+
+// ParseReceiptName 解析单一窗口回执文件名，支持以下格式，不区分大小写，无法识别时 Action 为 other：
+// Receipt_<原始报文编号>[_...].xml 业务回执；
+// Successed_<文件名>.<报文 ID>[(n)].xml 与 Failed_<文件名>.<报文 ID>[(n)].xml 导入结果；
+// CEBnnn[Message]_<原始报文编号>[_...].xml 跨境电商回执
+func ParseReceiptName(file string) *ReceiptName {
+	var parts = strings.Split(strings.Replace(file, "\\", "/", -1), "/")
+	var r = &ReceiptName{
+		File:     parts[len(parts)-1],
+		Business: BusinessOther,
+		Action:   ReceiptActionOther,
+	}
+
+	// 回执目录的上一级是业务子目录
+	for i := len(parts) - 2; i > 0; i-- {
+		if strings.EqualFold("inbox", parts[i]) {
+			r.Business = receiptBusiness(parts[i-1])
+			break
+		}
+	}
+
+	var stem = strings.ToLower(r.File)
+	if i := strings.LastIndex(stem, "."); i > 0 && strings.HasSuffix(stem, ".xml") {
+		stem = stem[:i]
+	}
+
+	var fields = strings.SplitN(stem, "_", 3)
+	var head = fields[0]
+	var next string
+	if len(fields) > 1 {
+		next = fields[1]
+	}
+
+	switch {
+	case "receipt" == head:
+		// 服务器按旧版本的取值处理，Receipt_201800001.xml 的原始报文编号为 201800001.xml
+		r.Action = ReceiptActionReceipt
+		r.ID = "0"
+		if names := strings.SplitN(strings.ToLower(r.File), "_", 3); len(names) > 1 {
+			r.OriginalBN = names[1]
+		}
+	case "successed" == head || "success" == head:
+		r.Action = ReceiptActionStatus
+		r.Status = "successed"
+		r.ID = receiptID(stem)
+	case "failed" == head || "fail" == head:
+		r.Action = ReceiptActionStatus
+		r.Status = "failed"
+		r.ID = receiptID(stem)
+	case isCEBType(head):
+		r.Action = ReceiptActionReceipt
+		r.Business = BusinessCEB
+		r.ID = "0"
+		r.MessageType = strings.ToUpper(head[:6])
+		r.OriginalBN = next
+	}
+
+	return r
+}
+
+// receiptBusiness 根据业务子目录名返回业务类型
+func receiptBusiness(dir string) string {
+	dir = strings.ToLower(dir)
+	for _, v := range businessDirs {
+		if strings.HasPrefix(dir, v.Prefix) {
+			return v.Business
+		}
+	}
+
+	return BusinessOther
+}
+
+// receiptID 返回导入结果文件名中第一个 . 之后到 ( 或下一个 . 之前的报文 ID，没有时返回空字符串
+func receiptID(stem string) string {
+	var i = strings.Index(stem, ".")
+	if i < 0 {
+		return ""
+	}
+
+	var id = stem[i+1:]
+	if j := strings.IndexAny(id, ".("); j >= 0 {
+		id = id[:j]
+	}
+
+	return id
+}
+
+// isCEBType 判断是否为 CEBnnn 开头的跨境电商报文类型
+func isCEBType(v string) bool {
+	if len(v) < 6 || "ceb" != v[:3] {
+		return false
+	}
+
+	for _, c := range v[3:6] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// receiptFieldNames 回执业务字段对应的 XML 元素名，元素名忽略命名空间、大小写与下划线，靠前的优先
+var receiptFieldNames = map[string][]string{
+	"entry_id":      {"entryid", "invtno", "billno"},
+	"cop_no":        {"copno", "clientseqno"},
+	"seq_no":        {"seqno", "cusciqno", "preno"},
+	"return_status": {"channel", "returnstatus", "responsecode", "code"},
+	"note":          {"note", "returninfo", "errormessage", "failinfo", "text"},
+	"notice_time":   {"noticedate", "returntime", "responsetime", "noticetime"},
+}
+
+// receiptTimeLayouts 回执中常见的时间格式，带毫秒的 20060102150405000 格式去掉毫秒后按 20060102150405 解析
+var receiptTimeLayouts = []string{
+	"20060102150405",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// ReceiptFields 从回执内容中提取的业务字段
+type ReceiptFields struct {
+	Root         string `label:"根元素名"`
+	EntryID      string `label:"报关单号或清单编号"`
+	CopNo        string `label:"企业内部编号"`
+	SeqNo        string `label:"统一编号或预录入编号"`
+	ReturnStatus string `label:"查验通道或回执状态码"`
+	Note         string `label:"回执说明"`
+	NoticeTime   string `label:"回执时间，可以识别时格式化为 2006-01-02 15:04:05"`
+}
+
+// Param 返回非空字段组成的上传参数
+func (f *ReceiptFields) Param() map[string]string {
+	var param = make(map[string]string)
+	for k, v := range map[string]string{
+		"root":          f.Root,
+		"entry_id":      f.EntryID,
+		"cop_no":        f.CopNo,
+		"seq_no":        f.SeqNo,
+		"return_status": f.ReturnStatus,
+		"note":          f.Note,
+		"notice_time":   f.NoticeTime,
+	} {
+		if "" != v {
+			param[k] = v
+		}
+	}
+
+	return param
+}
+
+// ParseReceiptFields 从报关单、舱单与跨境电商等回执中提取常用业务字段，各字段取第一个非空的同名元素，
+// 解析出错时返回已提取的字段与错误
+func ParseReceiptFields(raw []byte) (*ReceiptFields, error) {
+	var err error
+	var texts []string
+	var values = make(map[string]string)
+	var fields = new(ReceiptFields)
+	var d = xml.NewDecoder(bytes.NewReader(raw))
+
+	// 只提取字段，非 UTF-8 编码的内容按原样读取
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	for {
+		var token xml.Token
+		if token, err = d.Token(); nil != err {
+			break
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if "" == fields.Root {
+				fields.Root = t.Name.Local
+			}
+
+			texts = append(texts, "")
+		case xml.CharData:
+			if n := len(texts); n > 0 {
+				texts[n-1] += string(t)
+			}
+		case xml.EndElement:
+			if n := len(texts); n > 0 {
+				var name = strings.ToLower(strings.Replace(t.Name.Local, "_", "", -1))
+				if v := strings.TrimSpace(texts[n-1]); "" != v && "" == values[name] {
+					values[name] = v
+				}
+
+				texts = texts[:n-1]
+			}
+		}
+	}
+	if io.EOF == err {
+		err = nil
+	}
+
+	var pick = func(key string) string {
+		for _, name := range receiptFieldNames[key] {
+			if v := values[name]; "" != v {
+				return v
+			}
+		}
+
+		return ""
+	}
+
+	fields.EntryID = pick("entry_id")
+	fields.CopNo = pick("cop_no")
+	fields.SeqNo = pick("seq_no")
+	fields.ReturnStatus = pick("return_status")
+	fields.Note = pick("note")
+	fields.NoticeTime = pick("notice_time")
+
+	var notice = fields.NoticeTime
+	if 17 == len(notice) && !strings.ContainsAny(notice, "-: T") {
+		notice = notice[:14]
+	}
+	for _, layout := range receiptTimeLayouts {
+		if t, e := time.ParseInLocation(layout, notice, time.Local); nil == e {
+			fields.NoticeTime = t.Format("2006-01-02 15:04:05")
+			break
+		}
+	}
+
+	return fields, err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestParseReceiptName 解析各种回执文件名，无法识别的文件名不会出错
+func TestParseReceiptName(t *testing.T) {
+	var cases = []struct {
+		file string
+		want ReceiptName
+	}{
+		{"failed_x.xml", ReceiptName{File: "failed_x.xml", Business: BusinessOther, Action: ReceiptActionStatus, Status: "failed"}},
+		{"Failed.xml", ReceiptName{File: "Failed.xml", Business: BusinessOther, Action: ReceiptActionStatus, Status: "failed"}},
+		{"successed_a.123(1).xml", ReceiptName{File: "successed_a.123(1).xml", Business: BusinessOther, Action: ReceiptActionStatus, Status: "successed", ID: "123"}},
+		{"Receipt_201800001_x.XML", ReceiptName{File: "Receipt_201800001_x.XML", Business: BusinessOther, Action: ReceiptActionReceipt, ID: "0", OriginalBN: "201800001"}},
+		{"CEB312Message_201800002.xml", ReceiptName{File: "CEB312Message_201800002.xml", Business: BusinessCEB, Action: ReceiptActionReceipt, ID: "0", OriginalBN: "201800002", MessageType: "CEB312"}},
+		{`C:\ImpPath\DecCus001\InBox\Receipt_201800003.xml`, ReceiptName{File: "Receipt_201800003.xml", Business: BusinessDeclaration, Action: ReceiptActionReceipt, ID: "0", OriginalBN: "201800003.xml"}},
+		{"C:/ImpPath/RMft/InBox/Failed_b.456.xml", ReceiptName{File: "Failed_b.456.xml", Business: BusinessManifest, Action: ReceiptActionStatus, Status: "failed", ID: "456"}},
+		{"C:/ImpPath/DecCus001/OutBox/Receipt_1.xml", ReceiptName{File: "Receipt_1.xml", Business: BusinessOther, Action: ReceiptActionReceipt, ID: "0", OriginalBN: "1.xml"}},
+		{"", ReceiptName{Business: BusinessOther, Action: ReceiptActionOther}},
+		{"/", ReceiptName{Business: BusinessOther, Action: ReceiptActionOther}},
+		{"..xml", ReceiptName{File: "..xml", Business: BusinessOther, Action: ReceiptActionOther}},
+		{"InBox/CEB", ReceiptName{File: "CEB", Business: BusinessOther, Action: ReceiptActionOther}},
+	}
+
+	for _, c := range cases {
+		var got *ReceiptName
+		func() {
+			defer func() {
+				if err := recover(); nil != err {
+					t.Errorf("%q：解析时出错 %v", c.file, err)
+				}
+			}()
+
+			got = ParseReceiptName(c.file)
+		}()
+
+		if nil != got && c.want != *got {
+			t.Errorf("%q：解析结果为 %+v，应为 %+v", c.file, *got, c.want)
+		}
+	}
+}
+
+// TestParseReceiptFields 从报关单与跨境电商回执中提取业务字段，时间统一格式化
+func TestParseReceiptFields(t *testing.T) {
+	var cases = []struct {
+		name string
+		raw  string
+		want ReceiptFields
+		fail bool
+	}{
+		{"报关单回执", `<?xml version="1.0" encoding="UTF-8"?>
+<DEC_RESULT>
+	<CUS_CIQ_NO>I20180000123456789</CUS_CIQ_NO>
+	<ENTRY_ID>310120181234567890</ENTRY_ID>
+	<NOTICE_DATE>2018-06-01T10:20:30</NOTICE_DATE>
+	<CHANNEL>L</CHANNEL>
+	<NOTE>通关无纸化审结</NOTE>
+	<CUSTOM_MASTER>2244</CUSTOM_MASTER>
+</DEC_RESULT>`, ReceiptFields{
+			Root:         "DEC_RESULT",
+			EntryID:      "310120181234567890",
+			SeqNo:        "I20180000123456789",
+			ReturnStatus: "L",
+			Note:         "通关无纸化审结",
+			NoticeTime:   "2018-06-01 10:20:30",
+		}, false},
+		{"跨境电商清单回执", `<?xml version="1.0" encoding="UTF-8"?>
+<ceb:CEB622Message guid="4CDE1CFD-EDED-46B1-946C-B8022E42FC94" version="1.0" xmlns:ceb="http://www.chinaport.gov.cn/ceb">
+	<ceb:InventoryReturn>
+		<ceb:guid>4CDE1CFD-EDED-46B1-946C-B8022E42FC94</ceb:guid>
+		<ceb:customsCode>2244</ceb:customsCode>
+		<ceb:copNo>COP20180601001</ceb:copNo>
+		<ceb:preNo>B20180601000000001</ceb:preNo>
+		<ceb:invtNo>22442018I000000001</ceb:invtNo>
+		<ceb:returnStatus>800</ceb:returnStatus>
+		<ceb:returnTime>20180601102030123</ceb:returnTime>
+		<ceb:returnInfo>[Code:2600;Desc:放行]</ceb:returnInfo>
+	</ceb:InventoryReturn>
+</ceb:CEB622Message>`, ReceiptFields{
+			Root:         "CEB622Message",
+			EntryID:      "22442018I000000001",
+			CopNo:        "COP20180601001",
+			SeqNo:        "B20180601000000001",
+			ReturnStatus: "800",
+			Note:         "[Code:2600;Desc:放行]",
+			NoticeTime:   "2018-06-01 10:20:30",
+		}, false},
+		{"无法识别的时间保持原样", `<R><ReturnTime>明天</ReturnTime></R>`, ReceiptFields{Root: "R", NoticeTime: "明天"}, false},
+		{"格式错误时返回已提取的字段", `<R><EntryId>1</EntryId><Note>`, ReceiptFields{Root: "R", EntryID: "1"}, true},
+		{"空内容", ``, ReceiptFields{}, false},
+	}
+
+	for _, c := range cases {
+		var got, err = ParseReceiptFields([]byte(c.raw))
+		if c.fail != (nil != err) {
+			t.Errorf("%s：返回错误 %v", c.name, err)
+		}
+		if nil == got || c.want != *got {
+			t.Errorf("%s：提取的字段为 %+v，应为 %+v", c.name, got, c.want)
+		}
+	}
+
+	var fields = &ReceiptFields{Root: "R", EntryID: "1"}
+	if param := fields.Param(); 2 != len(param) || "R" != param["root"] || "1" != param["entry_id"] {
+		t.Errorf("上传参数应只包含非空字段：%v", param)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrResponseExpired 服务器响应时间超出允许的时差
+var ErrResponseExpired = errors.New("服务器响应时间超出允许的时差，可能是重放的数据")
+
+// ErrResponseNoTime 服务器响应缺少消息时间
+var ErrResponseNoTime = errors.New("服务器响应缺少消息时间，无法校验时差")
+
+// Sign 使用 Token 对请求参数做 HMAC-SHA256 签名，参数按键名排序后以表单格式拼接作为签名内容
+func Sign(token string, param map[string]string) string {
+	var v = make(url.Values)
+	for k1, v1 := range param {
+		if "sign" != k1 {
+			v.Set(k1, v1)
+		}
+	}
+
+	var mac = hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(v.Encode()))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignParam 返回附加了时间戳、随机数与签名的请求参数副本
+func SignParam(token string, now time.Time, param map[string]string) map[string]string {
+	var nonce = make([]byte, 16)
+	var ret = make(map[string]string, len(param)+3)
+	for k, v := range param {
+		ret[k] = v
+	}
+
+	rand.Read(nonce)
+	ret["timestamp"] = strconv.FormatInt(now.Unix(), 10)
+	ret["nonce"] = hex.EncodeToString(nonce)
+	ret["sign"] = Sign(token, ret)
+
+	return ret
+}
+
+// ParseMessageTime 解析服务器消息时间，支持 Unix 时间戳与 2006-01-02 15:04:05 格式
+func ParseMessageTime(v string) (time.Time, error) {
+	if ts, err := strconv.ParseInt(v, 10, 64); nil == err {
+		return time.Unix(ts, 0), nil
+	}
+
+	return time.ParseInLocation("2006-01-02 15:04:05", v, time.Local)
+}
+
+// CheckMessageTime 检查服务器消息时间与当前时间的差值是否在允许范围内
+func CheckMessageTime(msg *Message, now time.Time, lag time.Duration) error {
+	if "" == msg.Time {
+		return ErrResponseNoTime
+	}
+
+	var t, err = ParseMessageTime(msg.Time)
+	if nil != err {
+		return ErrResponseExpired
+	}
+
+	if d := now.Sub(t); d > lag || d < -lag {
+		return ErrResponseExpired
+	}
+
+	return nil
+}
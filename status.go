@@ -0,0 +1,49 @@
+package main
+
+import (
+	"time"
+)
+
+// Status 本地运行状态
+type Status struct {
+	Version     string   `json:"version" label:"程序版本号"`
+	Running     bool     `json:"running" label:"是否正在运行"`
+	Uptime      int64    `json:"uptime" label:"本次启动后运行的秒数"`
+	DataPath    string   `json:"data_path" label:"单一窗口数据目录"`
+	InBox       []string `json:"inbox" label:"监听的回执目录"`
+	Counter     Counter  `json:"counter" label:"计数器"`
+	Queue       int      `json:"queue" label:"待上传的回执数量"`
+	LastError   string   `json:"last_error" label:"最近一次错误信息"`
+	LastErrorAt int64    `json:"last_error_at" label:"最近一次错误的 Unix 时间戳，没有错误时为 0"`
+	ClockSkew   float64  `json:"clock_skew" label:"本机时间与服务器时间的偏差秒数，本机慢时为正"`
+}
+
+// Status 返回本地运行状态
+func (exe *Execute) Status() *Status {
+	var s = &Status{
+		Version:  Version,
+		Running:  exe.options.Status,
+		DataPath: exe.options.DataPath,
+		InBox:    exe.inboxDirs(),
+		Counter:  *exe.counter(),
+	}
+
+	if s.Running && !exe.startAt.IsZero() {
+		s.Uptime = int64(time.Since(exe.startAt) / time.Second)
+	}
+	if nil != exe.queue {
+		s.Queue = exe.queue.Len()
+	}
+	if nil != exe.client {
+		s.ClockSkew = exe.client.Clock().Offset().Seconds()
+	}
+
+	exe.errMux.Lock()
+	if "" != exe.lastErr {
+		s.LastError = exe.lastErr
+		s.LastErrorAt = exe.lastErrAt.Unix()
+	}
+	exe.errMux.Unlock()
+
+	return s
+}
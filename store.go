@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// OpenStore 打开本地持久化存储，文件不存在时自动创建
+func OpenStore(file string) (*Store, error) {
+	var db, err = bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if nil != err {
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Store 基于 bbolt 的本地持久化存储，值以 JSON 格式保存
+type Store struct {
+	db *bolt.DB `label:"数据库"`
+}
+
+// Close 关闭存储
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Put 保存数据
+func (s *Store) Put(bucket string, key string, v interface{}) error {
+	var data, err = json.Marshal(v)
+	if nil != err {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var b, err = tx.CreateBucketIfNotExists([]byte(bucket))
+		if nil == err {
+			err = b.Put([]byte(key), data)
+		}
+
+		return err
+	})
+}
+
+// Append 以自增序号追加数据，键为前缀加序号，前缀相同时按写入顺序排列
+func (s *Store) Append(bucket string, prefix string, v interface{}) error {
+	var data, err = json.Marshal(v)
+	if nil != err {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var b, err = tx.CreateBucketIfNotExists([]byte(bucket))
+		if nil == err {
+			var seq, _ = b.NextSequence()
+
+			err = b.Put([]byte(prefix+fmt.Sprintf("%020d", seq)), data)
+		}
+
+		return err
+	})
+}
+
+// Get 读取数据，数据不存在时返回 false
+func (s *Store) Get(bucket string, key string, out interface{}) (bool, error) {
+	var found bool
+	var err = s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(bucket)); nil != b {
+			if data := b.Get([]byte(key)); nil != data {
+				found = true
+
+				return json.Unmarshal(data, out)
+			}
+		}
+
+		return nil
+	})
+
+	return found, err
+}
+
+// Delete 删除数据
+func (s *Store) Delete(bucket string, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(bucket)); nil != b {
+			return b.Delete([]byte(key))
+		}
+
+		return nil
+	})
+}
+
+// Each 按键顺序遍历数据，回调返回错误时停止遍历
+func (s *Store) Each(bucket string, fn func(key string, data []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(bucket)); nil != b {
+			return b.ForEach(func(k, v []byte) error {
+				return fn(string(k), v)
+			})
+		}
+
+		return nil
+	})
+}
+
+// Range 按键顺序遍历 [from, to) 范围内的数据，to 为空时遍历到最后，回调返回错误时停止遍历
+func (s *Store) Range(bucket string, from string, to string, fn func(key string, data []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(bucket)); nil != b {
+			var c = b.Cursor()
+			for k, v := c.Seek([]byte(from)); nil != k; k, v = c.Next() {
+				if "" != to && string(k) >= to {
+					break
+				}
+				if err := fn(string(k), v); nil != err {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// Count 返回数据条数
+func (s *Store) Count(bucket string) int {
+	var n int
+
+	s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(bucket)); nil != b {
+			n = b.Stats().KeyN
+		}
+
+		return nil
+	})
+
+	return n
+}
@@ -1,12 +1,12 @@
+//go:build windows
+// +build windows
+
 package main
 
 import (
-	"bytes"
 	"errors"
 	"log"
-	"os"
 	"strconv"
-	"time"
 
 	"github.com/lxn/walk"
 	"github.com/lxn/walk/declarative"
@@ -16,7 +16,6 @@ import (
 type UIMainWindow struct {
 	opt       *Options               `label:"配置选项"`
 	exe       *Execute               `label:"指令执行器"`
-	log       *os.File               `label:"日志文件"`
 	icon      *walk.Icon             `label:"应用主图标"`
 	ni        *walk.NotifyIcon       `label:"状态栏提示图标"`
 	mw        *walk.MainWindow       `label:"应用主界面窗口"`
@@ -41,14 +40,6 @@ func (ui *UIMainWindow) Init(opt *Options, exe *Execute) {
 		ErrorPresenter: declarative.ToolTipErrorPresenter{},
 	}
 
-	// 初始化日志记录
-	if ui.opt.Debug > 0 {
-		var flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
-		if fp, err := os.OpenFile(GetAppPath()+"/swa.log", flag, os.ModePerm); nil == err {
-			ui.log = fp
-		}
-	}
-
 	ui.SetNotify()
 }
 
@@ -80,13 +71,18 @@ func (ui *UIMainWindow) Show() error {
 
 							if "" != ui.opt.ECid && "" != ui.opt.UID {
 								if ui.opt.Status {
-									ui.exe.Stop()
-
-									if !ui.opt.Status {
-										acceptPB.SetText("开始(&a)")
-										acceptPB.SetImage(startImg)
-										settingPB.SetEnabled(true)
-									}
+									// Stop 要等待业务循环退出，业务循环可能正在更新界面，不能在界面线程中等待
+									acceptPB.SetEnabled(false)
+									go func() {
+										ui.exe.Stop()
+
+										ui.mw.Synchronize(func() {
+											acceptPB.SetText("开始(&a)")
+											acceptPB.SetImage(startImg)
+											acceptPB.SetEnabled(true)
+											settingPB.SetEnabled(true)
+										})
+									}()
 								} else {
 									ui.exe.Start()
 
@@ -229,8 +225,31 @@ func (ui *UIMainWindow) ShowSetting() (int, error) {
 						Text: "登录密码:",
 					},
 					declarative.LineEdit{
-						Text:         declarative.Bind("Pwd", declarative.SelRequired{}),
-						ToolTipText:  "账号登录密码",
+						Text:         declarative.Bind("Pwd"),
+						ToolTipText:  "账号登录密码，已保存密码或填写了设备密钥时可以不填",
+						PasswordMode: true,
+					},
+
+					declarative.Label{
+						Text: "授权方式:",
+					},
+					declarative.ComboBox{
+						Value:         declarative.Bind("AuthMode"),
+						BindingMember: "Value",
+						DisplayMember: "Label",
+						ToolTipText:   "旧版服务器只支持后台登录表单方式",
+						Model: []*AuthMode{
+							{AuthModeForm, "后台登录表单"},
+							{AuthModeToken, "API 访问令牌"},
+						},
+					},
+
+					declarative.Label{
+						Text: "设备密钥:",
+					},
+					declarative.LineEdit{
+						Text:         declarative.Bind("DeviceKey"),
+						ToolTipText:  "API 访问令牌方式下可以使用服务器分配的设备密钥代替登录密码",
 						PasswordMode: true,
 					},
 
@@ -274,7 +293,17 @@ func (ui *UIMainWindow) ShowSetting() (int, error) {
 						MinValue:    1,
 						MaxValue:    600,
 						Value:       declarative.Bind("Interval"),
-						ToolTipText: "从数通天下快捷报关服务器读取数据的间隔时间（单位为秒）",
+						ToolTipText: "没有新数据时从数通天下快捷报关服务器读取数据的最长间隔时间（单位为秒）",
+					},
+
+					declarative.Label{
+						Text: "最短间隔:",
+					},
+					declarative.Slider{
+						MinValue:    1,
+						MaxValue:    600,
+						Value:       declarative.Bind("MinInterval"),
+						ToolTipText: "有新数据时从数通天下快捷报关服务器读取数据的最短间隔时间（单位为秒）",
 					},
 
 					declarative.Label{
@@ -287,6 +316,16 @@ func (ui *UIMainWindow) ShowSetting() (int, error) {
 						ToolTipText: "数通天下快捷报关服务器时间与当前电脑时间的最大差值（单位为秒）",
 					},
 
+					declarative.Label{
+						Text: "并发数量:",
+					},
+					declarative.Slider{
+						MinValue:    1,
+						MaxValue:    16,
+						Value:       declarative.Bind("Workers"),
+						ToolTipText: "同时执行的远程命令数量，同一分组的命令始终按顺序执行",
+					},
+
 					declarative.Label{
 						Text: "日志级别:",
 					},
@@ -321,6 +360,9 @@ func (ui *UIMainWindow) ShowSetting() (int, error) {
 
 							needReLoad = true
 							if err = ui.db.Submit(); nil == err {
+								// 先按新的超时时间等选项重新配置客户端，再验证账号授权
+								ui.exe.Reload()
+
 								if err = ui.opt.Validate(); nil == err {
 									err = ui.opt.Save()
 								} else if ErrNeedValidateAuth == err {
@@ -348,6 +390,7 @@ func (ui *UIMainWindow) ShowSetting() (int, error) {
 							// 如果配置保存失败，需要恢复到之前的状态，以免程序运行出错
 							if needReLoad {
 								ui.opt.Load()
+								ui.exe.Reload()
 							}
 						},
 					},
@@ -357,57 +400,28 @@ func (ui *UIMainWindow) ShowSetting() (int, error) {
 	}.Run(ui.mw)
 }
 
-// Tip 显示提示信息
-// category 消息类型: notify 任务栏提示消息, info 信息提示框, warning 警告信息, error 错误提示
-// level 消息级别：0 不记录日志 1 严重错误 2 常规错误 3 信息提示 4 调试信息
-// msg 消息内容：如果只有一个值表示消息内容，如果有两个值第一个是标题第二个是内容体
-func (ui *UIMainWindow) Tip(category string, level int, msg ...string) {
-	if ui.opt.Debug > 0 && level <= ui.opt.Debug {
-		if nil != ui.log {
-			var buf = new(bytes.Buffer)
-			buf.WriteString(time.Now().Format("2006-01-02 15:04:05"))
-			buf.WriteString(" [")
-			buf.WriteString(category)
-			buf.WriteString("] ")
-
-			for _, v := range msg {
-				buf.WriteString(v)
-			}
-
-			buf.WriteString("\r\n")
+// Notify 显示事件通知，计数器事件更新状态栏，严重错误弹出提示框，其它错误与提示信息显示任务栏气泡
+func (ui *UIMainWindow) Notify(e *Event) {
+	if EventCounter == e.Kind && nil != e.Counter {
+		ui.SetCounter(e.Counter)
 
-			ui.log.Write(buf.Bytes())
-		}
+		return
+	}
 
-		var title, message string
-		if len(msg) > 1 {
-			title = msg[0]
-			message = msg[1]
-		} else if len(msg) > 0 {
+	if ui.opt.Debug > 0 && e.Level <= ui.opt.Debug && "" != e.Message {
+		var title = e.Title
+		if "" == title {
 			title = "数据通天下 - 快捷报关数据传输助手"
-			message = msg[0]
-		} else {
-			return
 		}
 
-		switch category {
-		case "notify":
-			if 1 == level || 2 == level {
-				ui.ni.ShowError(title, message)
-			} else if 3 == level {
-				ui.ni.ShowInfo(title, message)
-			}
-		case "info":
-			if level < 4 {
-				walk.MsgBox(ui.mw, title, message, walk.MsgBoxIconInformation)
-			}
-		case "warning":
-			if level < 4 {
-				walk.MsgBox(ui.mw, title, message, walk.MsgBoxIconWarning)
-			}
-		case "error":
-			if level < 4 {
-				walk.MsgBox(ui.mw, title, message, walk.MsgBoxIconError)
+		switch e.Level {
+		case LevelFatal:
+			walk.MsgBox(ui.mw, title, e.Message, walk.MsgBoxIconError)
+		case LevelError:
+			ui.ni.ShowError(title, e.Message)
+		case LevelInfo:
+			if EventMessage == e.Kind || EventCommandFailed == e.Kind || EventAuthExpired == e.Kind {
+				ui.ni.ShowInfo(title, e.Message)
 			}
 		default:
 		}
@@ -439,7 +453,4 @@ func (ui *UIMainWindow) Clean() {
 	if nil != ui.ni {
 		ui.ni.Dispose()
 	}
-	if nil != ui.log {
-		ui.log.Close()
-	}
 }
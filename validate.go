@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ValidationRule 按根元素匹配的报文结构规则
+type ValidationRule struct {
+	Root     string   `json:"root" label:"根元素名，不含命名空间前缀"`
+	Required []string `json:"required" label:"必须存在的元素路径，相对根元素并以 / 分隔"`
+}
+
+// validationRules 内置的报文结构规则，配置了相同根元素的规则时以配置为准
+var validationRules = []*ValidationRule{
+	{Root: "DecMessage", Required: []string{"DecHead", "DecLists"}},
+	{Root: "Manifest", Required: []string{"Head", "Declaration"}},
+	{Root: "CEB311Message", Required: []string{"Order", "BaseTransfer"}},
+	{Root: "CEB411Message", Required: []string{"Payment", "BaseTransfer"}},
+	{Root: "CEB511Message", Required: []string{"Logistics", "BaseTransfer"}},
+	{Root: "CEB621Message", Required: []string{"Inventory", "BaseTransfer"}},
+}
+
+// ValidateXML 检查报文是否为格式正确的 XML，并按根元素对应的结构规则检查必须存在的元素，元素名不区分大小写
+func ValidateXML(content []byte, rules []*ValidationRule) error {
+	if 0 == len(bytes.TrimSpace(content)) {
+		return errors.New("报文内容为空")
+	}
+
+	var root string
+	var stack []string
+	var paths = make(map[string]bool)
+	var d = xml.NewDecoder(bytes.NewReader(content))
+
+	// 写入前的报文已是 UTF-8 编码，XML 声明中的编码只用于写入文件
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	for {
+		var token, err = d.Token()
+		if io.EOF == err {
+			break
+		} else if nil != err {
+			return errors.New("报文不是格式正确的 XML：" + err.Error())
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			var p string
+			if 0 == len(stack) {
+				if "" != root {
+					return errors.New("报文只能有一个根元素")
+				}
+
+				root = t.Name.Local
+			} else if p = strings.ToLower(t.Name.Local); "" != stack[len(stack)-1] {
+				p = stack[len(stack)-1] + "/" + p
+			}
+
+			if "" != p {
+				paths[p] = true
+			}
+
+			stack = append(stack, p)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if "" == root {
+		return errors.New("报文没有根元素")
+	}
+
+	var rule = findValidationRule(root, rules)
+	if nil != rule {
+		for _, p := range rule.Required {
+			if !paths[strings.ToLower(strings.Trim(p, "/"))] {
+				return errors.New("报文 " + root + " 缺少元素 " + p)
+			}
+		}
+	}
+
+	return nil
+}
+
+// findValidationRule 返回根元素对应的结构规则，配置的规则优先于内置规则
+func findValidationRule(root string, rules []*ValidationRule) *ValidationRule {
+	for _, list := range [][]*ValidationRule{rules, validationRules} {
+		for _, rule := range list {
+			if nil != rule && strings.EqualFold(root, rule.Root) {
+				return rule
+			}
+		}
+	}
+
+	return nil
+}
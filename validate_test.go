@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestValidateXML 检查报文格式与根元素对应的必须元素，元素名不区分大小写并忽略命名空间
+func TestValidateXML(t *testing.T) {
+	var rules = []*ValidationRule{
+		{Root: "DecMessage", Required: []string{"DecHead/AgentCode"}},
+		{Root: "Custom", Required: []string{"/Head/", "Body"}},
+	}
+
+	var cases = []struct {
+		content string
+		err     string
+	}{
+		{`<DecMessage><DecHead><AgentCode>1</AgentCode></DecHead></DecMessage>`, ""},
+		{`<?xml version="1.0" encoding="GBK"?><dec:DecMessage xmlns:dec="http://www.chinaport.gov.cn/dec"><dec:dechead><dec:agentcode/></dec:dechead></dec:DecMessage>`, ""},
+		{`<Custom><head/><BODY/></Custom>`, ""},
+		{`<CEB311Message><Order/><BaseTransfer/></CEB311Message>`, ""},
+		{`<Unknown/>`, ""},
+		{``, "报文内容为空"},
+		{"  \r\n", "报文内容为空"},
+		{`<?xml version="1.0"?>`, "报文没有根元素"},
+		{`<DecMessage><DecHead></DecMessage>`, "格式正确"},
+		{`<DecMessage>`, "格式正确"},
+		{`<a>&bad;</a>`, "格式正确"},
+		{`<a/><b/>`, "只能有一个根元素"},
+		{`<DecMessage><DecHead/></DecMessage>`, "缺少元素 DecHead/AgentCode"},
+		{`<DecMessage><AgentCode/><DecHead/></DecMessage>`, "缺少元素 DecHead/AgentCode"},
+		{`<Custom><Head/></Custom>`, "缺少元素 Body"},
+		{`<CEB311Message><Order/></CEB311Message>`, "缺少元素 BaseTransfer"},
+		{`<Manifest><Head/></Manifest>`, "缺少元素 Declaration"},
+	}
+
+	for _, c := range cases {
+		var err = ValidateXML([]byte(c.content), rules)
+		if "" == c.err {
+			if nil != err {
+				t.Errorf("%q：%v", c.content, err)
+			}
+		} else if nil == err || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%q：返回 %v，应包含 %q", c.content, err, c.err)
+		}
+	}
+}
+
+// TestFindValidationRule 配置的规则优先于内置规则
+func TestFindValidationRule(t *testing.T) {
+	var custom = &ValidationRule{Root: "decmessage"}
+	if rule := findValidationRule("DecMessage", []*ValidationRule{nil, custom}); custom != rule {
+		t.Errorf("应使用配置的规则：%+v", rule)
+	}
+	if rule := findValidationRule("Manifest", []*ValidationRule{custom}); nil == rule || "Manifest" != rule.Root {
+		t.Errorf("应使用内置规则：%+v", rule)
+	}
+	if rule := findValidationRule("Unknown", nil); nil != rule {
+		t.Errorf("没有对应的规则时应返回 nil：%+v", rule)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// NewWebhookNotifier 创建把事件以 JSON 格式推送到指定 URL 的通知
+func NewWebhookNotifier(opt *Options, url string) *WebhookNotifier {
+	var w = &WebhookNotifier{
+		opt:    opt,
+		url:    url,
+		queue:  make(chan *Event, 100),
+		client: &http.Client{Timeout: time.Second * 10},
+	}
+
+	go w.run()
+
+	return w
+}
+
+// WebhookNotifier 异步推送事件到 Webhook 地址，推送失败的事件直接丢弃
+type WebhookNotifier struct {
+	opt    *Options     `label:"配置选项"`
+	url    string       `label:"Webhook 地址"`
+	queue  chan *Event  `label:"待推送事件队列"`
+	client *http.Client `label:"HTTP 客户端"`
+}
+
+// Notify 事件放入推送队列，队列已满时丢弃事件以免阻塞业务流程，只推送不超过 Webhook 级别的普通事件，与日志级别无关
+func (w *WebhookNotifier) Notify(e *Event) {
+	if EventCounter == e.Kind || EventDebug == e.Kind || e.Level > LevelInfo || e.Level > w.opt.WebhookLevel {
+		return
+	}
+
+	select {
+	case w.queue <- e:
+	default:
+	}
+}
+
+// run 推送事件
+func (w *WebhookNotifier) run() {
+	for e := range w.queue {
+		if data, err := json.Marshal(e); nil == err {
+			if resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(data)); nil == err {
+				resp.Body.Close()
+			}
+		}
+	}
+}